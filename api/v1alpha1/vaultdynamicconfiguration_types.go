@@ -0,0 +1,164 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VaultDynamicConfigurationSpec defines the desired state of VaultDynamicConfiguration
+type VaultDynamicConfigurationSpec struct {
+	// PolicyTemplate is a Go template that will be rendered into the policy attached to each service account's role. The available values are '.Name', '.Namespace', '.Labels' and '.Annotations' of the service account, '.ClusterName', '.VaultName', '.DBRoles' (the names of the service account's database roles) and '.DatabaseMountPath', and the Sprig functions can be used.
+	// +optional
+	PolicyTemplate string `json:"policyTemplate,omitempty"`
+
+	// PolicyTemplates is a library of named Go templates that service accounts can select with an annotation. Each selected template will be rendered into a separate policy attached to the service account's role, with the same values as PolicyTemplate.
+	// +optional
+	PolicyTemplates map[string]string `json:"policyTemplates,omitempty"`
+
+	// AllowedPolicies are the names of existing Vault policies that service accounts and VaultRoles in any namespace can attach, in addition to the VaultPolicies in their own namespace.
+	// +optional
+	AllowedPolicies []string `json:"allowedPolicies,omitempty"`
+
+	// DbUserCreationStatement is the creation statement of the database roles for dynamic credentials on MySQL/MariaDB connections.
+	// +optional
+	DbUserCreationStatement string `json:"dbUserCreationStatement,omitempty"`
+
+	// DatabasePlugins are the statements of the database roles for dynamic credentials, by the 'plugin_name' of the target database connection. Statements that aren't set take the operator's defaults for the plugin.
+	// +optional
+	DatabasePlugins map[string]DatabaseStatements `json:"databasePlugins,omitempty"`
+
+	// DatabaseProfiles are named sets of database role statements, by plugin name, that service accounts can select with an annotation instead of the statements of DatabasePlugins.
+	// +optional
+	DatabaseProfiles map[string]map[string]DatabaseStatements `json:"databaseProfiles,omitempty"`
+
+	// AwsPolicyTemplates is a library of named Go templates that service accounts can select with an annotation. The selected template will be rendered into the JSON 'policy_document' of an 'iam_user' role of the AWS secrets engine, with the same values as PolicyTemplate.
+	// +optional
+	AwsPolicyTemplates map[string]string `json:"awsPolicyTemplates,omitempty"`
+
+	// DbDefaultTtl is the 'default_ttl' of the database roles for dynamic credentials.
+	// +optional
+	DbDefaultTtl string `json:"dbDefaultTtl,omitempty"`
+
+	// DbMaxTtl is the 'max_ttl' of the database roles for dynamic credentials.
+	// +optional
+	DbMaxTtl string `json:"dbMaxTtl,omitempty"`
+
+	// PkiAllowedDomains are Go templates that will be rendered into the 'allowed_domains' of the PKI roles for service accounts, with the same values as PolicyTemplate. If not set, the roles allow '<name>.<namespace>.svc' and '<name>.<namespace>.svc.cluster.local'.
+	// +optional
+	PkiAllowedDomains []string `json:"pkiAllowedDomains,omitempty"`
+
+	// PkiMaxTtl is the 'max_ttl' of the PKI roles for service accounts.
+	// +optional
+	PkiMaxTtl string `json:"pkiMaxTtl,omitempty"`
+
+	// PkiKeyType is the 'key_type' of the PKI roles for service accounts.
+	// +kubebuilder:validation:Enum=rsa;ec;ed25519;any
+	// +optional
+	PkiKeyType string `json:"pkiKeyType,omitempty"`
+
+	// SshAllowedUsers are Go templates that will be rendered into the 'allowed_users' of the SSH roles for service accounts, with the same values as PolicyTemplate. The first one is also the role's 'default_user'. If not set, the roles allow a user with the same name as the service account.
+	// +optional
+	SshAllowedUsers []string `json:"sshAllowedUsers,omitempty"`
+
+	// SshTtl is the 'ttl' of the SSH roles for service accounts.
+	// +optional
+	SshTtl string `json:"sshTtl,omitempty"`
+
+	// SshMaxTtl is the 'max_ttl' of the SSH roles for service accounts.
+	// +optional
+	SshMaxTtl string `json:"sshMaxTtl,omitempty"`
+
+	// KvPathTemplate is a Go template that will be rendered into the path of the KV secrets of each service account, relative to the mount path of the KV secrets engine, with the same values as PolicyTemplate. Defaults to the name of the role of the service account.
+	// +optional
+	KvPathTemplate string `json:"kvPathTemplate,omitempty"`
+
+	// KvAccess are the capabilities of the levels of access to the KV secrets that service accounts can select with an annotation. They're added to the operator's 'read' and 'write' levels, and can override them.
+	// +optional
+	KvAccess map[string]KvAccess `json:"kvAccess,omitempty"`
+
+	// RoleDefaults are the token settings of the roles created by the operator, unless they're overridden. If 'tokenTtl' is not set, the value of the --token-ttl flag will be used.
+	// +optional
+	RoleDefaults TokenSettings `json:"roleDefaults,omitempty"`
+}
+
+// DatabaseStatements are the statements of a database role for dynamic credentials. Statements are rendered as Go templates with '[[' and ']]' as delimiters (to not clash with Vault's '{{name}}'-style placeholders), with the same values as policy templates.
+type DatabaseStatements struct {
+	// CreationStatements corresponds to the role's 'creation_statements'.
+	// +optional
+	CreationStatements []string `json:"creationStatements,omitempty"`
+
+	// RevocationStatements corresponds to the role's 'revocation_statements'.
+	// +optional
+	RevocationStatements []string `json:"revocationStatements,omitempty"`
+
+	// RenewStatements corresponds to the role's 'renew_statements'.
+	// +optional
+	RenewStatements []string `json:"renewStatements,omitempty"`
+
+	// RollbackStatements corresponds to the role's 'rollback_statements'.
+	// +optional
+	RollbackStatements []string `json:"rollbackStatements,omitempty"`
+}
+
+// KvAccess are the capabilities of a level of access to the KV secrets of a service account.
+type KvAccess struct {
+	// Data are the capabilities on the secrets, i.e. on '<mount>/data/<path>' for version 2 of the KV secrets engine, or '<mount>/<path>' for version 1.
+	// +optional
+	Data []string `json:"data,omitempty"`
+
+	// Metadata are the capabilities on '<mount>/metadata/<path>'. Only used for version 2 of the KV secrets engine.
+	// +optional
+	Metadata []string `json:"metadata,omitempty"`
+
+	// Delete are the capabilities on '<mount>/delete/<path>'. Only used for version 2 of the KV secrets engine.
+	// +optional
+	Delete []string `json:"delete,omitempty"`
+}
+
+// VaultDynamicConfigurationStatus defines the observed state of VaultDynamicConfiguration
+type VaultDynamicConfigurationStatus struct {
+	// Conditions report whether the configuration is valid.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+
+// VaultDynamicConfiguration is the Schema for the vaultdynamicconfigurations API
+type VaultDynamicConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VaultDynamicConfigurationSpec   `json:"spec,omitempty"`
+	Status VaultDynamicConfigurationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VaultDynamicConfigurationList contains a list of VaultDynamicConfiguration
+type VaultDynamicConfigurationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VaultDynamicConfiguration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VaultDynamicConfiguration{}, &VaultDynamicConfigurationList{})
+}
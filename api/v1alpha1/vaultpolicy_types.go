@@ -0,0 +1,64 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VaultPolicySpec defines the desired state of VaultPolicy
+type VaultPolicySpec struct {
+	// Rules is the raw HCL of the policy. Exactly one of Rules or Template must be set.
+	// +optional
+	Rules string `json:"rules,omitempty"`
+
+	// Template is a Go template that will be rendered into the rules of the policy. The available values are '.Name', '.Namespace', '.Labels' and '.Annotations' of the VaultPolicy, '.ClusterName' and '.VaultName', and the Sprig functions can be used. Exactly one of Rules or Template must be set.
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
+// VaultPolicyStatus defines the observed state of VaultPolicy
+type VaultPolicyStatus struct {
+	// Conditions report whether the policy was applied to the Vault configuration.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// VaultPolicy is the Schema for the vaultpolicies API
+type VaultPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VaultPolicySpec   `json:"spec,omitempty"`
+	Status VaultPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VaultPolicyList contains a list of VaultPolicy
+type VaultPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VaultPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VaultPolicy{}, &VaultPolicyList{})
+}
@@ -0,0 +1,114 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VaultRoleSpec defines the desired state of VaultRole
+type VaultRoleSpec struct {
+	// ServiceAccounts is the list of names of the ServiceAccounts, in the same namespace as the VaultRole, that will be bound to the role.
+	// +kubebuilder:validation:MinItems=1
+	ServiceAccounts []string `json:"serviceAccounts"`
+
+	// Policies is the list of policies that will be attached to the role.
+	// +optional
+	Policies []VaultRolePolicy `json:"policies,omitempty"`
+
+	// TokenSettings are the settings of the role's tokens. Settings that aren't set take their value from the role defaults of the operator configuration.
+	TokenSettings `json:",inline"`
+}
+
+// TokenSettings are the settings of the tokens issued for a Kubernetes auth role.
+type TokenSettings struct {
+	// TokenTtl corresponds to the role's 'token_ttl'.
+	// +optional
+	TokenTtl string `json:"tokenTtl,omitempty"`
+
+	// TokenMaxTtl corresponds to the role's 'token_max_ttl'.
+	// +optional
+	TokenMaxTtl string `json:"tokenMaxTtl,omitempty"`
+
+	// TokenBoundCidrs corresponds to the role's 'token_bound_cidrs'.
+	// +optional
+	TokenBoundCidrs []string `json:"tokenBoundCidrs,omitempty"`
+
+	// TokenExplicitMaxTtl corresponds to the role's 'token_explicit_max_ttl'.
+	// +optional
+	TokenExplicitMaxTtl string `json:"tokenExplicitMaxTtl,omitempty"`
+
+	// TokenNoDefaultPolicy corresponds to the role's 'token_no_default_policy'.
+	// +optional
+	TokenNoDefaultPolicy bool `json:"tokenNoDefaultPolicy,omitempty"`
+
+	// TokenNumUses corresponds to the role's 'token_num_uses'.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TokenNumUses int `json:"tokenNumUses,omitempty"`
+
+	// TokenPeriod corresponds to the role's 'token_period'.
+	// +optional
+	TokenPeriod string `json:"tokenPeriod,omitempty"`
+
+	// TokenType corresponds to the role's 'token_type'.
+	// +kubebuilder:validation:Enum=service;batch;default;default-service;default-batch
+	// +optional
+	TokenType string `json:"tokenType,omitempty"`
+}
+
+// VaultRolePolicy is a reference to a Vault policy to attach to a role.
+type VaultRolePolicy struct {
+	// Name is the name of the policy. If Template is not set, it must be the name of a policy that already exists in the Vault configuration.
+	Name string `json:"name"`
+
+	// Template is a Go template that will be rendered into the rules of a new policy called Name. The available values are '.Name', '.Namespace', '.Labels' and '.Annotations' of the VaultRole, '.ClusterName' and '.VaultName', and the Sprig functions can be used.
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
+// VaultRoleStatus defines the observed state of VaultRole
+type VaultRoleStatus struct {
+	// Conditions report whether the role was applied to the Vault configuration.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// VaultRole is the Schema for the vaultroles API
+type VaultRole struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VaultRoleSpec   `json:"spec,omitempty"`
+	Status VaultRoleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VaultRoleList contains a list of VaultRole
+type VaultRoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VaultRole `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VaultRole{}, &VaultRoleList{})
+}
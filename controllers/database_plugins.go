@@ -0,0 +1,261 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
+)
+
+const defaultPostgresCreationStatement = "CREATE ROLE \"{{name}}\" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}'; GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO \"{{name}}\";"
+const defaultPostgresRevocationStatement = "REVOKE ALL PRIVILEGES ON ALL TABLES IN SCHEMA public FROM \"{{name}}\"; REVOKE ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public FROM \"{{name}}\"; REVOKE ALL PRIVILEGES ON ALL FUNCTIONS IN SCHEMA public FROM \"{{name}}\"; REVOKE USAGE ON SCHEMA public FROM \"{{name}}\"; DROP ROLE IF EXISTS \"{{name}}\";"
+const defaultPostgresRenewStatement = "ALTER ROLE \"{{name}}\" VALID UNTIL '{{expiration}}';"
+const defaultPostgresRollbackStatement = "DROP ROLE IF EXISTS \"{{name}}\";"
+const defaultMSSQLCreationStatement = "CREATE LOGIN [{{name}}] WITH PASSWORD = '{{password}}'; CREATE USER [{{name}}] FOR LOGIN [{{name}}]; GRANT SELECT, INSERT, UPDATE, DELETE, EXECUTE ON SCHEMA::dbo TO [{{name}}];"
+const defaultCassandraRevocationStatement = "DROP USER '{{username}}';"
+
+// defaultDatabaseStatements returns the default statements of the database roles, by plugin name.
+// MySQL/MariaDB roles are created with the creation statement of the operator configuration. The
+// plugins without creation statements have no sensible least-privilege default (e.g. it depends on
+// the databases, keyspaces or indices of each application), so they must be set explicitly.
+func defaultDatabaseStatements(config vaultv1alpha1.VaultDynamicConfigurationSpec) map[string]vaultv1alpha1.DatabaseStatements {
+	mysql := vaultv1alpha1.DatabaseStatements{
+		CreationStatements: []string{config.DbUserCreationStatement},
+	}
+	return map[string]vaultv1alpha1.DatabaseStatements{
+		"mysql-database-plugin":        mysql,
+		"mysql-aurora-database-plugin": mysql,
+		"mysql-rds-database-plugin":    mysql,
+		"mysql-legacy-database-plugin": mysql,
+		"postgresql-database-plugin": {
+			CreationStatements:   []string{defaultPostgresCreationStatement},
+			RevocationStatements: []string{defaultPostgresRevocationStatement},
+			RenewStatements:      []string{defaultPostgresRenewStatement},
+			RollbackStatements:   []string{defaultPostgresRollbackStatement},
+		},
+		"mongodb-database-plugin": {},
+		"mssql-database-plugin": {
+			CreationStatements: []string{defaultMSSQLCreationStatement},
+		},
+		"cassandra-database-plugin": {
+			RevocationStatements: []string{defaultCassandraRevocationStatement},
+			RollbackStatements:   []string{defaultCassandraRevocationStatement},
+		},
+		"redis-database-plugin":         {},
+		"elasticsearch-database-plugin": {},
+	}
+}
+
+// statementsForPlugin returns the statements of the database roles for a connection, based on its
+// plugin. The statements of the selected profile (if any) take precedence over the ones set in the
+// operator configuration for the plugin, which take precedence over the defaults. Plugins without
+// defaults (e.g. custom plugins) get the creation statement of the operator configuration, like all
+// plugins did before the statements depended on the plugin.
+func statementsForPlugin(pluginName string, profile string, config vaultv1alpha1.VaultDynamicConfigurationSpec) (vaultv1alpha1.DatabaseStatements, error) {
+	statements, ok := defaultDatabaseStatements(config)[pluginName]
+	if !ok {
+		statements = vaultv1alpha1.DatabaseStatements{
+			CreationStatements: []string{config.DbUserCreationStatement},
+		}
+	}
+	statements = overrideStatements(statements, config.DatabasePlugins[pluginName])
+	if profile != "" {
+		profileStatements, ok := config.DatabaseProfiles[profile]
+		if !ok {
+			return vaultv1alpha1.DatabaseStatements{}, &serviceAccountError{reason: "DatabaseProfileNotFound", err: fmt.Errorf("Database profile %s not found in the operator configuration", profile)}
+		}
+		override, ok := profileStatements[pluginName]
+		if !ok {
+			return vaultv1alpha1.DatabaseStatements{}, &serviceAccountError{reason: "DatabaseProfileNotFound", err: fmt.Errorf("Database profile %s has no statements for plugin '%s'", profile, pluginName)}
+		}
+		statements = overrideStatements(statements, override)
+	}
+	if len(statements.CreationStatements) == 0 {
+		return vaultv1alpha1.DatabaseStatements{}, &serviceAccountError{reason: "DatabaseStatementsNotSet", err: fmt.Errorf("Database plugin '%s' has no default creation statements, they must be set in 'databasePlugins' or in a database profile", pluginName)}
+	}
+	return statements, nil
+}
+
+func overrideStatements(statements vaultv1alpha1.DatabaseStatements, override vaultv1alpha1.DatabaseStatements) vaultv1alpha1.DatabaseStatements {
+	if len(override.CreationStatements) > 0 {
+		statements.CreationStatements = override.CreationStatements
+	}
+	if len(override.RevocationStatements) > 0 {
+		statements.RevocationStatements = override.RevocationStatements
+	}
+	if len(override.RenewStatements) > 0 {
+		statements.RenewStatements = override.RenewStatements
+	}
+	if len(override.RollbackStatements) > 0 {
+		statements.RollbackStatements = override.RollbackStatements
+	}
+	return statements
+}
+
+// newStatementTemplate returns an empty database statement template. It uses '[[' and ']]' as
+// delimiters, so Vault's own '{{name}}' and '{{password}}' placeholders are left as they are.
+func newStatementTemplate(name string) *template.Template {
+	return newPolicyTemplate(name).Delims("[[", "]]")
+}
+
+// renderStatements renders all the statements with the given input.
+func renderStatements(statements vaultv1alpha1.DatabaseStatements, input policyTemplateInput) (vaultv1alpha1.DatabaseStatements, error) {
+	all := []struct {
+		field      string
+		statements *[]string
+	}{
+		{"creationStatements", &statements.CreationStatements},
+		{"revocationStatements", &statements.RevocationStatements},
+		{"renewStatements", &statements.RenewStatements},
+		{"rollbackStatements", &statements.RollbackStatements},
+	}
+	for _, s := range all {
+		if *s.statements == nil {
+			continue
+		}
+		rendered := make([]string, len(*s.statements))
+		for i, statement := range *s.statements {
+			tpl, err := newStatementTemplate(s.field).Parse(statement)
+			if err == nil {
+				var b bytes.Buffer
+				err = tpl.Execute(&b, input)
+				rendered[i] = b.String()
+			}
+			if err != nil {
+				return vaultv1alpha1.DatabaseStatements{}, &serviceAccountError{reason: "DatabaseStatementError", err: fmt.Errorf("Error rendering database statements: %w", err)}
+			}
+		}
+		*s.statements = rendered
+	}
+	return statements, nil
+}
+
+func validateDatabasePlugins(config vaultv1alpha1.VaultDynamicConfigurationSpec) error {
+	err := validateDatabaseStatementsByPlugin("databasePlugins", config.DatabasePlugins)
+	if err != nil {
+		return err
+	}
+	for profile, plugins := range config.DatabaseProfiles {
+		err = validateDatabaseStatementsByPlugin(fmt.Sprintf("databaseProfiles.%s", profile), plugins)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateDatabaseStatementsByPlugin(fieldPrefix string, plugins map[string]vaultv1alpha1.DatabaseStatements) error {
+	for pluginName, statements := range plugins {
+		field := fmt.Sprintf("%s.%s", fieldPrefix, pluginName)
+		err := validateDatabaseStatements(field, pluginName, statements)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDatabaseStatements checks that none of the statements are empty, and that the statements of
+// the plugins that take JSON documents instead of SQL are valid.
+func validateDatabaseStatements(field string, pluginName string, statements vaultv1alpha1.DatabaseStatements) error {
+	all := []struct {
+		field      string
+		statements []string
+	}{
+		{"creationStatements", statements.CreationStatements},
+		{"revocationStatements", statements.RevocationStatements},
+		{"renewStatements", statements.RenewStatements},
+		{"rollbackStatements", statements.RollbackStatements},
+	}
+	for _, s := range all {
+		for i, statement := range s.statements {
+			if statement == "" {
+				return fmt.Errorf("%s.%s[%d]: must not be empty", field, s.field, i)
+			}
+			_, err := newStatementTemplate(s.field).Parse(statement)
+			if err != nil {
+				return fmt.Errorf("%s.%s[%d]: %v", field, s.field, i, err)
+			}
+		}
+	}
+	for i, statement := range statements.CreationStatements {
+		var err error
+		switch pluginName {
+		case "mongodb-database-plugin":
+			err = validateMongoDBStatement(statement, true)
+		case "redis-database-plugin":
+			err = validateRedisStatement(statement)
+		case "elasticsearch-database-plugin":
+			err = validateElasticsearchStatement(statement)
+		}
+		if err != nil {
+			return fmt.Errorf("%s.creationStatements[%d]: %v", field, i, err)
+		}
+	}
+	if pluginName == "mongodb-database-plugin" {
+		for i, statement := range statements.RevocationStatements {
+			err := validateMongoDBStatement(statement, false)
+			if err != nil {
+				return fmt.Errorf("%s.revocationStatements[%d]: %v", field, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateMongoDBStatement(statement string, requireRoles bool) error {
+	var document struct {
+		Db    string        `json:"db"`
+		Roles []interface{} `json:"roles"`
+	}
+	err := json.Unmarshal([]byte(statement), &document)
+	if err != nil {
+		return fmt.Errorf("must be a JSON document: %v", err)
+	}
+	if requireRoles && len(document.Roles) == 0 {
+		return errors.New("must set 'roles'")
+	}
+	return nil
+}
+
+func validateRedisStatement(statement string) error {
+	var rules []string
+	err := json.Unmarshal([]byte(statement), &rules)
+	if err != nil {
+		return fmt.Errorf("must be a JSON list of ACL rules: %v", err)
+	}
+	return nil
+}
+
+func validateElasticsearchStatement(statement string) error {
+	var document map[string]interface{}
+	err := json.Unmarshal([]byte(statement), &document)
+	if err != nil {
+		return fmt.Errorf("must be a JSON document: %v", err)
+	}
+	_, hasRoles := document["elasticsearch_roles"]
+	_, hasDefinition := document["elasticsearch_role_definition"]
+	if !hasRoles && !hasDefinition {
+		return errors.New("must set 'elasticsearch_roles' or 'elasticsearch_role_definition'")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// aggregateRequest is the only request queued by debouncedHandler. The reconciler it's used with
+// ignores the request and reconciles everything it's responsible for.
+var aggregateRequest = reconcile.Request{
+	NamespacedName: types.NamespacedName{Name: "aggregate"},
+}
+
+// debouncedHandler queues aggregateRequest after window for every event. Since the queue
+// deduplicates requests that are waiting to be processed, a burst of events results in a single
+// reconciliation.
+type debouncedHandler struct {
+	window time.Duration
+}
+
+func (h *debouncedHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	q.AddAfter(aggregateRequest, h.window)
+}
+
+func (h *debouncedHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	q.AddAfter(aggregateRequest, h.window)
+}
+
+func (h *debouncedHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	q.AddAfter(aggregateRequest, h.window)
+}
+
+func (h *debouncedHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	q.AddAfter(aggregateRequest, h.window)
+}
@@ -0,0 +1,191 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bankvaultsv1alpha1 "github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+)
+
+const managedEntriesAnnotation = "managed-entries"
+const serviceAccountOwner = "ServiceAccount"
+const adoptedOwner = "Adopted"
+
+// managedEntries is the ledger of roles, policies, database roles and entries of other secrets engines
+// that were created by the operator, mapped to the owner they were created for. It's stored as an
+// annotation on the target Vault CR, so it's always updated together with the configuration it describes.
+type managedEntries struct {
+	Roles    map[string]string `json:"roles,omitempty"`
+	Policies map[string]string `json:"policies,omitempty"`
+	DBRoles  map[string]string `json:"dbRoles,omitempty"`
+
+	// SecretEngineEntries are the entries of other secrets engines, by '<engine type>/<name>'.
+	SecretEngineEntries map[string]string `json:"secretEngineEntries,omitempty"`
+}
+
+// deepCopy returns a copy of the ledger that can be changed without affecting the original.
+func (entries *managedEntries) deepCopy() *managedEntries {
+	c := &managedEntries{
+		Roles:               map[string]string{},
+		Policies:            map[string]string{},
+		DBRoles:             map[string]string{},
+		SecretEngineEntries: map[string]string{},
+	}
+	for _, l := range []struct{ from, to map[string]string }{
+		{entries.Roles, c.Roles},
+		{entries.Policies, c.Policies},
+		{entries.DBRoles, c.DBRoles},
+		{entries.SecretEngineEntries, c.SecretEngineEntries},
+	} {
+		for k, v := range l.from {
+			l.to[k] = v
+		}
+	}
+	return c
+}
+
+// getManagedEntries reads the ledger from the Vault CR. If the CR doesn't have the annotation yet
+// (i.e. it was configured by a version of the operator that didn't keep a ledger), the roles that
+// match the shape of the ones generated for service accounts are recorded as adopted, along with their
+// policy and database role. Adopted entries are only taken over by annotated ServiceAccounts with the
+// same name, and are never pruned, since they might have been written by hand.
+func getManagedEntries(vaultConfig *bankvaultsv1alpha1.Vault, bvConfig BankVaultsConfig) (*managedEntries, error) {
+	entries := &managedEntries{
+		Roles:    map[string]string{},
+		Policies: map[string]string{},
+		DBRoles:  map[string]string{},
+
+		SecretEngineEntries: map[string]string{},
+	}
+	if val, ok := vaultConfig.ObjectMeta.Annotations[AnnotationPrefix+"/"+managedEntriesAnnotation]; ok {
+		err := json.Unmarshal([]byte(val), entries)
+		if err != nil {
+			return nil, fmt.Errorf("Can't parse annotation %s/%s: %v", AnnotationPrefix, managedEntriesAnnotation, err)
+		}
+		return entries, nil
+	}
+	kubernetesAuth, err := bvConfig.getKubernetesAuth()
+	if err != nil {
+		return entries, nil
+	}
+	for _, r := range kubernetesAuth.Roles {
+		if r.BoundServiceAccountNames != r.Name || len(r.TokenPolicies) != 1 || r.TokenPolicies[0] != r.Name {
+			continue
+		}
+		entries.Roles[r.Name] = adoptedOwner
+		if _, err := bvConfig.GetPolicy(r.Name); err == nil {
+			entries.Policies[r.Name] = adoptedOwner
+		}
+		if _, err := bvConfig.GetDBRole(r.Name); err == nil {
+			entries.DBRoles[r.Name] = adoptedOwner
+		}
+	}
+	return entries, nil
+}
+
+// claimAdopted records the adopted entries with the given name as owned by the ServiceAccounts with
+// that name.
+func (entries *managedEntries) claimAdopted(name string) {
+	for _, ledger := range []map[string]string{entries.Roles, entries.Policies, entries.DBRoles} {
+		if ledger[name] == adoptedOwner {
+			ledger[name] = serviceAccountOwner
+		}
+	}
+}
+
+func setManagedEntries(vaultConfig *bankvaultsv1alpha1.Vault, entries *managedEntries) error {
+	jsonData, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if vaultConfig.ObjectMeta.Annotations == nil {
+		vaultConfig.ObjectMeta.Annotations = map[string]string{}
+	}
+	vaultConfig.ObjectMeta.Annotations[AnnotationPrefix+"/"+managedEntriesAnnotation] = string(jsonData)
+	return nil
+}
+
+// ownerKey identifies an object other than a ServiceAccount that owns entries in the ledger.
+// Roles generated for ServiceAccounts are shared by all the ServiceAccounts with the same name,
+// so they're all recorded as serviceAccountOwner instead.
+func ownerKey(kind string, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// ownershipError is returned when an entry the operator would create already exists in the Vault
+// configuration, but it wasn't created by the operator for the same owner.
+type ownershipError struct {
+	kind  string
+	name  string
+	owner string
+}
+
+func (e *ownershipError) Error() string {
+	return fmt.Sprintf("%s %s already exists and is not managed by %s", e.kind, e.name, e.owner)
+}
+
+func (entries *managedEntries) checkRoleOwnership(bvConfig BankVaultsConfig, name string, owner string) error {
+	if _, err := bvConfig.GetRole(name); err == nil && entries.Roles[name] != owner {
+		return &ownershipError{kind: "Role", name: name, owner: owner}
+	}
+	return nil
+}
+
+func (entries *managedEntries) checkPolicyOwnership(bvConfig BankVaultsConfig, name string, owner string) error {
+	if _, err := bvConfig.GetPolicy(name); err == nil && entries.Policies[name] != owner {
+		return &ownershipError{kind: "Policy", name: name, owner: owner}
+	}
+	return nil
+}
+
+func (entries *managedEntries) checkDBRoleOwnership(bvConfig BankVaultsConfig, name string, owner string) error {
+	if _, err := bvConfig.GetDBRole(name); err == nil && entries.DBRoles[name] != owner {
+		return &ownershipError{kind: "Database role", name: name, owner: owner}
+	}
+	return nil
+}
+
+// checkOwnership returns an error if the role or policy the operator would create for the given name
+// already exists in the configuration but wasn't created for the same owner.
+func (entries *managedEntries) checkOwnership(bvConfig BankVaultsConfig, name string, owner string) error {
+	err := entries.checkRoleOwnership(bvConfig, name, owner)
+	if err != nil {
+		return err
+	}
+	return entries.checkPolicyOwnership(bvConfig, name, owner)
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	result := []string{}
+	for _, e := range list {
+		if e != s {
+			result = append(result, e)
+		}
+	}
+	return result
+}
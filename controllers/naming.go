@@ -0,0 +1,96 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	NamingStrategy  string
+	NamingTemplate  string
+	KeepLegacyNames bool
+)
+
+const namingStrategyName = "name"
+const namingStrategyNamespaceName = "namespace-name"
+const namingStrategyTemplate = "template"
+
+// entryNamer computes the name of the role, policy and database role of a ServiceAccount. ServiceAccounts
+// that get the same name share the same entries.
+type entryNamer struct {
+	strategy string
+	template *template.Template
+}
+
+func newEntryNamer() (*entryNamer, error) {
+	switch NamingStrategy {
+	case namingStrategyName, namingStrategyNamespaceName:
+		return &entryNamer{strategy: NamingStrategy}, nil
+	case namingStrategyTemplate:
+		if NamingTemplate == "" {
+			return nil, errors.New("The naming template must be set when using the 'template' naming strategy")
+		}
+		tpl, err := newPolicyTemplate("namingTemplate").Parse(NamingTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid naming template: %w", err)
+		}
+		return &entryNamer{strategy: NamingStrategy, template: tpl}, nil
+	}
+	return nil, fmt.Errorf("Unknown naming strategy '%s', must be one of '%s', '%s' or '%s'", NamingStrategy, namingStrategyName, namingStrategyNamespaceName, namingStrategyTemplate)
+}
+
+func (n *entryNamer) name(metadata metav1.ObjectMeta) (string, error) {
+	switch n.strategy {
+	case namingStrategyNamespaceName:
+		return fmt.Sprintf("%s.%s", metadata.Namespace, metadata.Name), nil
+	case namingStrategyTemplate:
+		var b bytes.Buffer
+		err := n.template.Execute(&b, newPolicyTemplateInput(metadata))
+		if err != nil {
+			return "", fmt.Errorf("Error rendering naming template: %w", err)
+		}
+		name := strings.TrimSpace(b.String())
+		if name == "" {
+			return "", errors.New("Naming template rendered an empty name")
+		}
+		return name, nil
+	}
+	return metadata.Name, nil
+}
+
+// mergesNamespaces returns whether ServiceAccounts from different namespaces that get the same name
+// should share the same entries. That's only the case with the 'name' strategy, with the others it's a
+// collision that would grant each namespace the access of the other.
+func (n *entryNamer) mergesNamespaces() bool {
+	return n.strategy == namingStrategyName
+}
+
+// legacyName returns the name-only name of the ServiceAccount's entries, if they should be kept while
+// migrating to a different naming strategy.
+func (n *entryNamer) legacyName(metadata metav1.ObjectMeta) (string, bool) {
+	if !KeepLegacyNames || n.strategy == namingStrategyName {
+		return "", false
+	}
+	return metadata.Name, true
+}
@@ -0,0 +1,496 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	bankvaultsv1alpha1 "github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// secretEngineEntryLists maps the types of the secrets engines (other than database) the operator
+// manages entries for, to the list in their configuration where the entries are.
+var secretEngineEntryLists = map[string]string{
+	"aws":     "roles",
+	"pki":     "roles",
+	"ssh":     "roles",
+	"transit": "keys",
+}
+
+// defaultPkiAllowedDomains are the templates of the 'allowed_domains' of the PKI roles, which match the
+// in-cluster DNS names of a Service with the same name as the ServiceAccount.
+var defaultPkiAllowedDomains = []string{
+	"{{ .Name }}.{{ .Namespace }}.svc",
+	"{{ .Name }}.{{ .Namespace }}.svc.cluster.local",
+}
+
+// defaultSshAllowedUsers are the templates of the 'allowed_users' of the SSH roles.
+var defaultSshAllowedUsers = []string{"{{ .Name }}"}
+
+// defaultKvAccess are the capabilities of the levels of access to KV secrets that service accounts can
+// select with an annotation.
+var defaultKvAccess = map[string]vaultv1alpha1.KvAccess{
+	"read": {
+		Data:     []string{"read"},
+		Metadata: []string{"read", "list"},
+	},
+	"write": {
+		Data:     []string{"create", "read", "update", "delete"},
+		Metadata: []string{"read", "list", "delete"},
+		Delete:   []string{"update"},
+	},
+}
+
+// secretEngine is the configuration of a secrets engine in the Bank-Vaults configuration, with all of
+// its fields, so the ones the operator doesn't manage are written back as they were read.
+type secretEngine map[string]interface{}
+
+// secretEngineEntry is an entry of a secrets engine created for a role, along with the paths (relative
+// to the engine's mount path) its policy gets access to.
+type secretEngineEntry struct {
+	engineType   string
+	fields       map[string]interface{}
+	paths        []string
+	capabilities []string
+}
+
+func (e secretEngineEntry) name() string {
+	name, _ := e.fields["name"].(string)
+	return name
+}
+
+func getSecretEngines(externalConfig []byte) ([]secretEngine, error) {
+	config := struct {
+		Secrets []secretEngine `json:"secrets"`
+	}{}
+	err := json.Unmarshal(externalConfig, &config)
+	return config.Secrets, err
+}
+
+func (bvConfig BankVaultsConfig) getSecretEngine(engineType string) (secretEngine, error) {
+	for _, e := range bvConfig.engines {
+		if e["type"] == engineType {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("Secrets engine %s configuration not found", engineType)
+}
+
+// mountPath returns the path where the secrets engine is mounted, which defaults to its type.
+func (e secretEngine) mountPath() string {
+	if path, ok := e["path"].(string); ok && strings.Trim(path, "/") != "" {
+		return strings.Trim(path, "/")
+	}
+	engineType, _ := e["type"].(string)
+	return engineType
+}
+
+func (e secretEngine) configuration() map[string]interface{} {
+	configuration, ok := e["configuration"].(map[string]interface{})
+	if !ok {
+		configuration = map[string]interface{}{}
+		e["configuration"] = configuration
+	}
+	return configuration
+}
+
+func (e secretEngine) getEntry(list string, name string) (map[string]interface{}, bool) {
+	entries, _ := e.configuration()[list].([]interface{})
+	for _, entry := range entries {
+		if m, ok := entry.(map[string]interface{}); ok && m["name"] == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// upsertEntry adds the entry to the list, or replaces the one with the same name if it's different.
+func (e secretEngine) upsertEntry(list string, entry map[string]interface{}) {
+	jsonData, _ := json.Marshal(entry)
+	normalized := map[string]interface{}{}
+	json.Unmarshal(jsonData, &normalized)
+	configuration := e.configuration()
+	entries, _ := configuration[list].([]interface{})
+	for i, existing := range entries {
+		if m, ok := existing.(map[string]interface{}); ok && m["name"] == normalized["name"] {
+			if !reflect.DeepEqual(m, normalized) {
+				entries[i] = normalized
+			}
+			return
+		}
+	}
+	configuration[list] = append(entries, normalized)
+}
+
+func (e secretEngine) removeEntry(list string, name string) {
+	configuration := e.configuration()
+	entries, _ := configuration[list].([]interface{})
+	for i, entry := range entries {
+		if m, ok := entry.(map[string]interface{}); ok && m["name"] == name {
+			configuration[list] = append(entries[:i], entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// secretEngineEntryKey is the key of an entry of a secrets engine in the ledger.
+func secretEngineEntryKey(engineType string, name string) string {
+	return fmt.Sprintf("%s/%s", engineType, name)
+}
+
+func splitSecretEngineEntryKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func (entries *managedEntries) checkSecretEngineEntryOwnership(engine secretEngine, entry secretEngineEntry, owner string) error {
+	if _, ok := engine.getEntry(secretEngineEntryLists[entry.engineType], entry.name()); ok && entries.SecretEngineEntries[secretEngineEntryKey(entry.engineType, entry.name())] != owner {
+		return &ownershipError{kind: fmt.Sprintf("Secrets engine %s entry", entry.engineType), name: entry.name(), owner: owner}
+	}
+	return nil
+}
+
+// removeSecretEngineEntry removes an entry of a secrets engine, if the engine is still configured.
+func removeSecretEngineEntry(bvConfig *BankVaultsConfig, key string) {
+	engineType, name := splitSecretEngineEntryKey(key)
+	engine, err := bvConfig.getSecretEngine(engineType)
+	if err != nil {
+		return
+	}
+	engine.removeEntry(secretEngineEntryLists[engineType], name)
+}
+
+// secretEngineEntriesFromAnnotations returns the entries of secrets engines the annotations of a
+// ServiceAccount ask for, sorted by engine type.
+func secretEngineEntriesFromAnnotations(name string, metadata metav1.ObjectMeta, input policyTemplateInput, config vaultv1alpha1.VaultDynamicConfigurationSpec) ([]secretEngineEntry, error) {
+	engineEntries := []secretEngineEntry{}
+	awsRole, err := awsRoleFromAnnotations(name, metadata.Annotations, input, config)
+	if err != nil {
+		return nil, err
+	}
+	if awsRole != nil {
+		engineEntries = append(engineEntries, *awsRole)
+	}
+	pkiRole, err := pkiRoleFromAnnotations(name, metadata.Annotations, input, config)
+	if err != nil {
+		return nil, err
+	}
+	if pkiRole != nil {
+		engineEntries = append(engineEntries, *pkiRole)
+	}
+	sshRole, err := sshRoleFromAnnotations(name, metadata.Annotations, input, config)
+	if err != nil {
+		return nil, err
+	}
+	if sshRole != nil {
+		engineEntries = append(engineEntries, *sshRole)
+	}
+	transitKey, err := transitKeyFromAnnotations(name, metadata.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if transitKey != nil {
+		engineEntries = append(engineEntries, *transitKey)
+	}
+	sort.SliceStable(engineEntries, func(i, j int) bool {
+		return engineEntries[i].engineType < engineEntries[j].engineType
+	})
+	return engineEntries, nil
+}
+
+// awsRoleFromAnnotations returns the role of the AWS secrets engine for the annotations, or nil if
+// they don't ask for one. Roles for a list of role ARNs use the 'assumed_role' credential type, and roles
+// for a policy document use the 'iam_user' credential type.
+func awsRoleFromAnnotations(name string, annotations map[string]string, input policyTemplateInput, config vaultv1alpha1.VaultDynamicConfigurationSpec) (*secretEngineEntry, error) {
+	roleArns := splitAnnotationList(annotations[AnnotationPrefix+"/"+awsRoleArnAnnotation])
+	policyTemplate := strings.TrimSpace(annotations[AnnotationPrefix+"/"+awsPolicyTemplateAnnotation])
+	if len(roleArns) == 0 && policyTemplate == "" {
+		return nil, nil
+	}
+	role := &secretEngineEntry{
+		engineType:   "aws",
+		paths:        []string{fmt.Sprintf("creds/%s", name)},
+		capabilities: []string{"read"},
+	}
+	if len(roleArns) > 0 {
+		if policyTemplate != "" {
+			return nil, &serviceAccountError{reason: "InvalidAnnotation", err: fmt.Errorf("%s/%s and %s/%s can't be used together", AnnotationPrefix, awsRoleArnAnnotation, AnnotationPrefix, awsPolicyTemplateAnnotation)}
+		}
+		role.fields = map[string]interface{}{
+			"name":            name,
+			"credential_type": "assumed_role",
+			"role_arns":       roleArns,
+		}
+		return role, nil
+	}
+	t, ok := config.AwsPolicyTemplates[policyTemplate]
+	if !ok {
+		return nil, &serviceAccountError{reason: "PolicyTemplateNotFound", err: fmt.Errorf("AWS policy template %s not found in the operator configuration", policyTemplate)}
+	}
+	policyDocument, err := renderPolicy(policyTemplate, t, input)
+	if err != nil {
+		return nil, &serviceAccountError{reason: "PolicyTemplateError", err: err}
+	}
+	if !json.Valid([]byte(policyDocument)) {
+		return nil, &serviceAccountError{reason: "PolicyTemplateError", err: fmt.Errorf("AWS policy template %s didn't render a valid JSON policy document", policyTemplate)}
+	}
+	role.fields = map[string]interface{}{
+		"name":            name,
+		"credential_type": "iam_user",
+		"policy_document": policyDocument,
+	}
+	return role, nil
+}
+
+// pkiRoleFromAnnotations returns the role of the PKI secrets engine for the annotations, or nil if they
+// don't ask for one. The role can issue certificates for the rendered allowed domains (but not their
+// subdomains), with the 'max_ttl' and 'key_type' of the operator configuration.
+func pkiRoleFromAnnotations(name string, annotations map[string]string, input policyTemplateInput, config vaultv1alpha1.VaultDynamicConfigurationSpec) (*secretEngineEntry, error) {
+	val, ok := annotations[AnnotationPrefix+"/"+pkiRoleAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		return nil, &serviceAccountError{reason: "InvalidAnnotation", err: fmt.Errorf("%s/%s: invalid boolean %s", AnnotationPrefix, pkiRoleAnnotation, val)}
+	}
+	if !enabled {
+		return nil, nil
+	}
+	allowedDomains := []string{}
+	for i, t := range config.PkiAllowedDomains {
+		domain, err := renderPolicy(fmt.Sprintf("pkiAllowedDomains[%d]", i), t, input)
+		if err != nil {
+			return nil, &serviceAccountError{reason: "PolicyTemplateError", err: err}
+		}
+		domain = strings.TrimSpace(domain)
+		if domain != "" && !containsString(allowedDomains, domain) {
+			allowedDomains = append(allowedDomains, domain)
+		}
+	}
+	return &secretEngineEntry{
+		engineType: "pki",
+		fields: map[string]interface{}{
+			"name":               name,
+			"allowed_domains":    allowedDomains,
+			"allow_bare_domains": true,
+			"allow_subdomains":   false,
+			"max_ttl":            config.PkiMaxTtl,
+			"key_type":           config.PkiKeyType,
+		},
+		paths:        []string{fmt.Sprintf("issue/%s", name)},
+		capabilities: []string{"update"},
+	}, nil
+}
+
+// sshRoleFromAnnotations returns the role of the SSH secrets engine for the annotations, or nil if they
+// don't ask for one. The role signs user certificates with the CA of the engine, for the rendered allowed
+// users (the first one being the default), with the 'ttl' and 'max_ttl' of the operator configuration.
+func sshRoleFromAnnotations(name string, annotations map[string]string, input policyTemplateInput, config vaultv1alpha1.VaultDynamicConfigurationSpec) (*secretEngineEntry, error) {
+	val, ok := annotations[AnnotationPrefix+"/"+sshRoleAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		return nil, &serviceAccountError{reason: "InvalidAnnotation", err: fmt.Errorf("%s/%s: invalid boolean %s", AnnotationPrefix, sshRoleAnnotation, val)}
+	}
+	if !enabled {
+		return nil, nil
+	}
+	allowedUsers := []string{}
+	for i, t := range config.SshAllowedUsers {
+		user, err := renderPolicy(fmt.Sprintf("sshAllowedUsers[%d]", i), t, input)
+		if err != nil {
+			return nil, &serviceAccountError{reason: "PolicyTemplateError", err: err}
+		}
+		user = strings.TrimSpace(user)
+		if user != "" && !containsString(allowedUsers, user) {
+			allowedUsers = append(allowedUsers, user)
+		}
+	}
+	role := &secretEngineEntry{
+		engineType: "ssh",
+		fields: map[string]interface{}{
+			"name":                    name,
+			"key_type":                "ca",
+			"allow_user_certificates": true,
+			"allowed_users":           strings.Join(allowedUsers, ","),
+			"ttl":                     config.SshTtl,
+			"max_ttl":                 config.SshMaxTtl,
+		},
+		paths:        []string{fmt.Sprintf("sign/%s", name)},
+		capabilities: []string{"update"},
+	}
+	if len(allowedUsers) > 0 {
+		role.fields["default_user"] = allowedUsers[0]
+	}
+	return role, nil
+}
+
+// transitKeyFromAnnotations returns the key of the transit secrets engine for the annotations, or nil if
+// they don't ask for one. Only the types of keys that support encryption are allowed, and if the type
+// isn't set, Vault's default is used.
+func transitKeyFromAnnotations(name string, annotations map[string]string) (*secretEngineEntry, error) {
+	val, ok := annotations[AnnotationPrefix+"/"+transitKeyAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		return nil, &serviceAccountError{reason: "InvalidAnnotation", err: fmt.Errorf("%s/%s: invalid boolean %s", AnnotationPrefix, transitKeyAnnotation, val)}
+	}
+	if !enabled {
+		return nil, nil
+	}
+	key := &secretEngineEntry{
+		engineType: "transit",
+		fields: map[string]interface{}{
+			"name": name,
+		},
+		paths:        []string{fmt.Sprintf("encrypt/%s", name), fmt.Sprintf("decrypt/%s", name)},
+		capabilities: []string{"update"},
+	}
+	if keyType := strings.TrimSpace(annotations[AnnotationPrefix+"/"+transitKeyTypeAnnotation]); keyType != "" {
+		switch keyType {
+		case "aes128-gcm96", "aes256-gcm96", "chacha20-poly1305", "rsa-2048", "rsa-3072", "rsa-4096":
+		default:
+			return nil, &serviceAccountError{reason: "InvalidAnnotation", err: fmt.Errorf("%s/%s: invalid key type %s", AnnotationPrefix, transitKeyTypeAnnotation, keyType)}
+		}
+		key.fields["type"] = keyType
+	}
+	if val, ok := annotations[AnnotationPrefix+"/"+transitKeyExportableAnnotation]; ok {
+		exportable, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return nil, &serviceAccountError{reason: "InvalidAnnotation", err: fmt.Errorf("%s/%s: invalid boolean %s", AnnotationPrefix, transitKeyExportableAnnotation, val)}
+		}
+		key.fields["exportable"] = exportable
+	}
+	return key, nil
+}
+
+// getKvEngine returns the configuration of the KV secrets engine, along with its version.
+func (bvConfig BankVaultsConfig) getKvEngine() (secretEngine, int, error) {
+	for _, e := range bvConfig.engines {
+		if e["type"] == "kv-v2" {
+			return e, 2, nil
+		}
+		if e["type"] != "kv" {
+			continue
+		}
+		options, _ := e["options"].(map[string]interface{})
+		switch v := options["version"].(type) {
+		case float64:
+			return e, int(v), nil
+		case string:
+			version, err := strconv.Atoi(v)
+			if err != nil {
+				return e, 0, fmt.Errorf("Invalid KV secrets engine version %s", v)
+			}
+			return e, version, nil
+		}
+		return e, 1, nil
+	}
+	return nil, 0, errors.New("Secrets engine kv configuration not found")
+}
+
+// appendKvStanzas adds the stanzas for the level of access to KV secrets the annotations ask for. For
+// version 2 of the engine, the data, metadata and delete paths get the capabilities of the level of
+// access, and for version 1 the path gets the capabilities on the data.
+func appendKvStanzas(bvConfig BankVaultsConfig, rules string, annotations map[string]string, input policyTemplateInput, config vaultv1alpha1.VaultDynamicConfigurationSpec) (string, error) {
+	level := strings.TrimSpace(annotations[AnnotationPrefix+"/"+kvAccessAnnotation])
+	if level == "" {
+		return rules, nil
+	}
+	access, ok := config.KvAccess[level]
+	if !ok {
+		return "", &serviceAccountError{reason: "InvalidAnnotation", err: fmt.Errorf("%s/%s: unknown level of access %s", AnnotationPrefix, kvAccessAnnotation, level)}
+	}
+	engine, version, err := bvConfig.getKvEngine()
+	if err != nil {
+		return "", &serviceAccountError{reason: "SecretsEngineNotFound", err: err}
+	}
+	path, err := renderPolicy("kvPathTemplate", config.KvPathTemplate, input)
+	if err != nil {
+		return "", &serviceAccountError{reason: "PolicyTemplateError", err: err}
+	}
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if version < 2 {
+		if len(access.Data) > 0 {
+			rules = appendPathStanza(rules, fmt.Sprintf("%s/%s", engine.mountPath(), path), access.Data...)
+		}
+		return rules, nil
+	}
+	stanzas := []struct {
+		prefix       string
+		capabilities []string
+	}{
+		{"data", access.Data},
+		{"metadata", access.Metadata},
+		{"delete", access.Delete},
+	}
+	for _, s := range stanzas {
+		if len(s.capabilities) > 0 {
+			rules = appendPathStanza(rules, fmt.Sprintf("%s/%s/%s", engine.mountPath(), s.prefix, path), s.capabilities...)
+		}
+	}
+	return rules, nil
+}
+
+// appendPathStanza adds a stanza to the policy rules to give the capabilities on the path.
+func appendPathStanza(rules string, path string, capabilities ...string) string {
+	quoted := []string{}
+	for _, c := range capabilities {
+		quoted = append(quoted, fmt.Sprintf("%q", c))
+	}
+	return fmt.Sprintf("%s\npath \"%s\" {\n  capabilities = [%s]\n}\n", strings.TrimRight(rules, "\n"), path, strings.Join(quoted, ", "))
+}
+
+func updateSecretEnginesConfiguration(bvConfig BankVaultsConfig, vaultConfig *bankvaultsv1alpha1.Vault) error {
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal([]byte(vaultConfig.Spec.ExternalConfigJSON()), &jsonMap)
+	if err != nil {
+		return err
+	}
+	secrets, ok := jsonMap["secrets"].([]interface{})
+	if !ok || len(secrets) != len(bvConfig.engines) {
+		return fmt.Errorf("Secrets engines configuration changed while it was being updated")
+	}
+	for i, e := range bvConfig.engines {
+		if e["type"] == "database" {
+			continue
+		}
+		secrets[i] = e
+	}
+	unmarshaledJsonMap, err := json.Marshal(jsonMap)
+	if err != nil {
+		return err
+	}
+	vaultConfig.Spec.ExternalConfig.Raw = unmarshaledJsonMap
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+var (
+	IncludeNamespaces      string
+	ExcludeNamespaces      string
+	NamespaceSelector      string
+	ServiceAccountSelector string
+)
+
+// serviceAccountScope decides which annotated ServiceAccounts the operator manages, based on their
+// namespace and labels.
+type serviceAccountScope struct {
+	includeNamespaces      []string
+	excludeNamespaces      []string
+	namespaceSelector      labels.Selector
+	serviceAccountSelector labels.Selector
+}
+
+func newServiceAccountScope() (*serviceAccountScope, error) {
+	namespaceSelector, err := labels.Parse(NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid namespace selector: %w", err)
+	}
+	serviceAccountSelector, err := labels.Parse(ServiceAccountSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid service account selector: %w", err)
+	}
+	return &serviceAccountScope{
+		includeNamespaces:      splitAnnotationList(IncludeNamespaces),
+		excludeNamespaces:      splitAnnotationList(ExcludeNamespaces),
+		namespaceSelector:      namespaceSelector,
+		serviceAccountSelector: serviceAccountSelector,
+	}, nil
+}
+
+// includes returns whether the ServiceAccount is in scope. The namespace is only looked up if a
+// namespace selector is set.
+func (s *serviceAccountScope) includes(c client.Client, sa *corev1.ServiceAccount) (bool, error) {
+	if !s.serviceAccountSelector.Matches(labels.Set(sa.ObjectMeta.Labels)) {
+		return false, nil
+	}
+	return s.includesNamespace(c, sa.ObjectMeta.Namespace)
+}
+
+// includesNamespace returns whether the namespace is in scope, which is all that's checked for
+// VaultRoles and VaultPolicies.
+func (s *serviceAccountScope) includesNamespace(c client.Client, ns string) (bool, error) {
+	if len(s.includeNamespaces) > 0 && !containsString(s.includeNamespaces, ns) {
+		return false, nil
+	}
+	if containsString(s.excludeNamespaces, ns) {
+		return false, nil
+	}
+	if s.namespaceSelector.Empty() {
+		return true, nil
+	}
+	namespace := &corev1.Namespace{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: ns}, namespace)
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.namespaceSelector.Matches(labels.Set(namespace.ObjectMeta.Labels)), nil
+}
+
+// checkNamespaceScope returns a *specError if a VaultRole or VaultPolicy in the namespace is out of
+// scope, after removing whatever was configured for it while it was in scope.
+func checkNamespaceScope(c client.Client, s *serviceAccountScope, ns string, remove func() error) error {
+	included, err := s.includesNamespace(c, ns)
+	if err != nil {
+		return err
+	}
+	if included {
+		return nil
+	}
+	err = remove()
+	if err != nil {
+		return err
+	}
+	return &specError{reason: "OutOfScope", err: fmt.Errorf("Namespace %s is out of the scope of the operator", ns)}
+}
+
+// predicate filters events for ServiceAccounts that aren't annotated or are out of scope, so changes to
+// unrelated ServiceAccounts don't trigger a reconciliation of all of them. ServiceAccounts that still
+// have the finalizer are let through, so their configuration is removed when they leave the scope, and
+// updates are let through if either version was annotated, so removing the annotation is seen too.
+func (s *serviceAccountScope) predicate(c client.Client) predicate.Predicate {
+	relevant := func(o runtime.Object) bool {
+		sa, ok := o.(*corev1.ServiceAccount)
+		if !ok {
+			return false
+		}
+		if controllerutil.ContainsFinalizer(sa, vaultConfigurationFinalizer) {
+			return true
+		}
+		if _, ok := sa.ObjectMeta.Annotations[AnnotationPrefix+"/"+AutoConfigureAnnotation]; !ok {
+			return false
+		}
+		included, err := s.includes(c, sa)
+		return included || err != nil
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return relevant(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return relevant(e.ObjectOld) || relevant(e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return relevant(e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return relevant(e.Object)
+		},
+	}
+}
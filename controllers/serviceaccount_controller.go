@@ -23,22 +23,31 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
+	"github.com/Masterminds/sprig/v3"
 	bankvaultsv1alpha1 "github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
 	"github.com/go-logr/logr"
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
-	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
@@ -50,6 +59,8 @@ var (
 	DynamicDBCredentialsAnnotation string
 	BoundRolesToAllNamespaces      bool
 	TokenTtl                       string
+	ClusterName                    string
+	DebounceWindow                 time.Duration
 )
 
 var log = logf.Log.WithName("controller_vdc")
@@ -58,11 +69,42 @@ const defaultPolicyTemplate = "path \"secret/{{ .Name }}\" { capabilities = [\"r
 const defaultDynamicDBUserCreationStatement = "CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}'; GRANT ALL ON *.* TO '{{name}}'@'%';"
 const defaultDbDefaultTtl = "1h"
 const defaultDbMaxTtl = "24h"
+const defaultPkiMaxTtl = "72h"
+const defaultPkiKeyType = "rsa"
+const defaultKvPathTemplate = "{{ .RoleName }}"
+const defaultSshTtl = "1h"
+const defaultSshMaxTtl = "24h"
+const vaultConfigurationFinalizer = "vault.patoarvizu.dev/vault-configuration"
+const policiesAnnotation = "policies"
+const policyTemplatesAnnotation = "policy-templates"
+const tokenTtlAnnotation = "token-ttl"
+const tokenMaxTtlAnnotation = "token-max-ttl"
+const tokenBoundCidrsAnnotation = "token-bound-cidrs"
+const tokenExplicitMaxTtlAnnotation = "token-explicit-max-ttl"
+const tokenNoDefaultPolicyAnnotation = "token-no-default-policy"
+const tokenNumUsesAnnotation = "token-num-uses"
+const tokenPeriodAnnotation = "token-period"
+const tokenTypeAnnotation = "token-type"
+const dbProfileAnnotation = "db-profile"
+const dbDefaultTtlAnnotation = "db-default-ttl"
+const dbMaxTtlAnnotation = "db-max-ttl"
+const awsRoleArnAnnotation = "aws-role-arn"
+const awsPolicyTemplateAnnotation = "aws-policy-template"
+const pkiRoleAnnotation = "pki-role"
+const kvAccessAnnotation = "kv-access"
+const sshRoleAnnotation = "ssh-role"
+const transitKeyAnnotation = "transit-key"
+const transitKeyTypeAnnotation = "transit-key-type"
+const transitKeyExportableAnnotation = "transit-key-exportable"
+const autoConfigureField = ".metadata.annotations.autoConfigure"
+const finalizersField = ".metadata.finalizers"
 
 type BankVaultsConfig struct {
 	Auth     []Auth   `json:"auth"`
 	Policies []Policy `json:"policies"`
 	Secrets  []Secret `json:"secrets,omitempty"`
+
+	engines []secretEngine
 }
 
 type Auth struct {
@@ -78,6 +120,7 @@ type Policy struct {
 
 type Secret struct {
 	Type          string          `json:"type"`
+	Path          string          `json:"path,omitempty"`
 	Configuration DBConfiguration `json:"configuration"`
 }
 
@@ -99,11 +142,14 @@ type DBConfig struct {
 }
 
 type DBRole struct {
-	Name               string   `json:"name"`
-	DbName             string   `json:"db_name"`
-	CreationStatements []string `json:"creation_statements"`
-	DefaultTtl         string   `json:"default_ttl,omitempty"`
-	MaxTtl             string   `json:"max_ttl,omitempty"`
+	Name                 string   `json:"name"`
+	DbName               string   `json:"db_name"`
+	CreationStatements   []string `json:"creation_statements"`
+	RevocationStatements []string `json:"revocation_statements,omitempty"`
+	RenewStatements      []string `json:"renew_statements,omitempty"`
+	RollbackStatements   []string `json:"rollback_statements,omitempty"`
+	DefaultTtl           string   `json:"default_ttl,omitempty"`
+	MaxTtl               string   `json:"max_ttl,omitempty"`
 }
 
 type Role struct {
@@ -122,123 +168,509 @@ type Role struct {
 }
 
 type policyTemplateInput struct {
-	Name      string
-	Namespace string
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+	ClusterName string
+	VaultName   string
+	RoleName    string
+	DBRoles     []string
+
+	DatabaseMountPath string
 }
 
-// ServiceAccountReconciler reconciles a ServiceAccount object
+// ServiceAccountReconciler reconciles all the annotated ServiceAccount objects at once
 type ServiceAccountReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	scope    *serviceAccountScope
+	namer    *entryNamer
 }
 
-// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=vault.patoarvizu.dev,resources=vaultdynamicconfigurations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups=vault.banzaicloud.com,resources=vaults,verbs=get;list;watch;create;update;patch
 
 func (r *ServiceAccountReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	reqLogger := log.WithValues("Request.Namespace", req.Namespace, "Request.Name", req.Name)
-
-	instance := &corev1.ServiceAccount{}
-	err := r.Client.Get(context.TODO(), req.NamespacedName, instance)
+	serviceAccounts, err := r.getConfiguredServiceAccounts()
 	if err != nil {
-		if k8serrors.IsNotFound(err) {
-			return reconcile.Result{}, nil
+		return reconcile.Result{}, err
+	}
+
+	desired := map[string][]corev1.ServiceAccount{}
+	// sources are the namespaces of the ServiceAccounts that get each name, or "" for legacy names.
+	sources := map[string][]string{}
+	released := []corev1.ServiceAccount{}
+	for _, sa := range serviceAccounts {
+		inScope, err := r.scope.includes(r.Client, &sa)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if val, ok := sa.Annotations[AnnotationPrefix+"/"+AutoConfigureAnnotation]; !ok || val != "true" || sa.ObjectMeta.DeletionTimestamp != nil || !inScope {
+			if controllerutil.ContainsFinalizer(&sa, vaultConfigurationFinalizer) {
+				released = append(released, sa)
+			}
+			continue
+		}
+		if sa.ObjectMeta.Name == "default" {
+			log.V(1).Info(fmt.Sprintf("Explicitly ignoring 'default' ServiceAccount in namespace %s, to avoid overwriting Vaults 'default' policy", sa.ObjectMeta.Namespace))
+			continue
+		}
+		name, err := r.namer.name(sa.ObjectMeta)
+		if err != nil {
+			r.reportServiceAccountError([]corev1.ServiceAccount{sa}, &serviceAccountError{reason: "InvalidName", err: err})
+			continue
+		}
+		desired[name] = append(desired[name], sa)
+		if !containsString(sources[name], sa.ObjectMeta.Namespace) {
+			sources[name] = append(sources[name], sa.ObjectMeta.Namespace)
+		}
+		if legacyName, ok := r.namer.legacyName(sa.ObjectMeta); ok && legacyName != name {
+			desired[legacyName] = append(desired[legacyName], sa)
+			if !containsString(sources[legacyName], "") {
+				sources[legacyName] = append(sources[legacyName], "")
+			}
+		}
+	}
+	names := []string{}
+	for name := range desired {
+		names = append(names, name)
+		sort.Slice(desired[name], func(i, j int) bool {
+			return desired[name][i].ObjectMeta.Namespace < desired[name][j].ObjectMeta.Namespace
+		})
+	}
+	sort.Strings(names)
+
+	// Entries of ServiceAccounts that are no longer configured are pruned even if the configuration
+	// is invalid, so their finalizers can be released.
+	config, configErr := getConfiguration(r.Client)
+	var failed map[string]*serviceAccountError
+	err = updateVaultConfig(r.Client, func(bvConfig *BankVaultsConfig, entries *managedEntries) error {
+		failed = map[string]*serviceAccountError{}
+		err := pruneServiceAccountEntries(bvConfig, entries, desired)
+		if err != nil {
+			return err
+		}
+		if configErr != nil {
+			return nil
+		}
+		for _, name := range names {
+			if len(sources[name]) > 1 && !r.namer.mergesNamespaces() {
+				failed[name] = &serviceAccountError{reason: "NameCollision", err: fmt.Errorf("Service accounts from different namespaces (or legacy names) are all named %s", name)}
+				continue
+			}
+			// Each name is applied to a copy, so the ones that fail partway don't leave partial changes.
+			candidate, err := bvConfig.deepCopy()
+			if err != nil {
+				return err
+			}
+			candidateEntries := entries.deepCopy()
+			err = applyServiceAccounts(&candidate, candidateEntries, config, name, desired[name])
+			var saErr *serviceAccountError
+			if errors.As(err, &saErr) {
+				failed[name] = saErr
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			*bvConfig, *entries = candidate, *candidateEntries
 		}
+		return nil
+	})
+	if k8serrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		log.Info("Target Vault CR not found, releasing ServiceAccounts that are no longer configured", "Vault", TargetVaultName)
+		return reconcile.Result{}, r.releaseServiceAccounts(released, nil)
+	}
+	if err != nil {
 		return reconcile.Result{}, err
 	}
+	if configErr != nil {
+		// An invalid VaultDynamicConfiguration is reported by its own controller, but nothing else
+		// watches the fallback ConfigMap.
+		if configMap, ok := getConfigurationSource(r.Client).(*corev1.ConfigMap); ok {
+			r.Recorder.Event(configMap, corev1.EventTypeWarning, "InvalidConfiguration", configErr.Error())
+		}
+		err = r.releaseServiceAccounts(released, nil)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, configErr
+	}
+	log.V(1).Info("Applied ServiceAccounts to Vault configuration", "ServiceAccounts", len(names)-len(failed))
+
+	applied := map[types.NamespacedName]corev1.ServiceAccount{}
+	for _, name := range names {
+		if saErr, ok := failed[name]; ok {
+			r.reportServiceAccountError(desired[name], saErr)
+			continue
+		}
+		for _, sa := range desired[name] {
+			applied[types.NamespacedName{Name: sa.ObjectMeta.Name, Namespace: sa.ObjectMeta.Namespace}] = sa
+		}
+	}
+	for _, sa := range applied {
+		if controllerutil.ContainsFinalizer(&sa, vaultConfigurationFinalizer) {
+			continue
+		}
+		controllerutil.AddFinalizer(&sa, vaultConfigurationFinalizer)
+		if updateErr := r.Client.Update(context.TODO(), &sa); updateErr != nil {
+			err = updateErr
+		}
+	}
+	if releaseErr := r.releaseServiceAccounts(released, failed); releaseErr != nil {
+		err = releaseErr
+	}
+	return reconcile.Result{}, err
+}
 
-	if val, ok := instance.Annotations[AnnotationPrefix+"/"+AutoConfigureAnnotation]; !ok || val != "true" {
-		return reconcile.Result{}, nil
+// releaseServiceAccounts removes the finalizer from the ServiceAccounts that are no longer configured,
+// unless their entries couldn't be updated.
+func (r *ServiceAccountReconciler) releaseServiceAccounts(released []corev1.ServiceAccount, failed map[string]*serviceAccountError) error {
+	var err error
+	for _, sa := range released {
+		if r.releaseFailed(sa.ObjectMeta, failed) {
+			continue
+		}
+		log.V(1).Info("Removed Vault configuration for ServiceAccount", "ServiceAccount", sa.ObjectMeta.Name, "Namespace", sa.ObjectMeta.Namespace)
+		controllerutil.RemoveFinalizer(&sa, vaultConfigurationFinalizer)
+		if updateErr := r.Client.Update(context.TODO(), &sa); updateErr != nil {
+			err = updateErr
+		}
 	}
+	return err
+}
 
-	if instance.ObjectMeta.Name == "default" {
-		reqLogger.V(1).Info(fmt.Sprintf("Explicitly ignoring 'default' ServiceAccount in namespace %s, to avoid overwriting Vaults 'default' policy", instance.ObjectMeta.Namespace))
-		return reconcile.Result{}, nil
+// releaseFailed returns whether the entries of a ServiceAccount that is no longer configured couldn't
+// be updated, in which case its finalizer must be kept.
+func (r *ServiceAccountReconciler) releaseFailed(metadata metav1.ObjectMeta, failed map[string]*serviceAccountError) bool {
+	if name, err := r.namer.name(metadata); err == nil && failed[name] != nil {
+		return true
+	}
+	if legacyName, ok := r.namer.legacyName(metadata); ok && failed[legacyName] != nil {
+		return true
 	}
+	return false
+}
 
-	vaultConfig := &bankvaultsv1alpha1.Vault{}
-	ns, _ := getOperatorNamespace()
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: TargetVaultName, Namespace: ns}, vaultConfig)
+// getConfiguredServiceAccounts returns the ServiceAccounts that are annotated to be configured,
+// along with the ones that still have the finalizer from a previous configuration, from the indexes
+// in the manager's cache.
+func (r *ServiceAccountReconciler) getConfiguredServiceAccounts() ([]corev1.ServiceAccount, error) {
+	annotated := &corev1.ServiceAccountList{}
+	err := r.Client.List(context.TODO(), annotated, client.MatchingFields{autoConfigureField: "true"})
 	if err != nil {
-		return reconcile.Result{}, err
+		return nil, err
 	}
-	var bvConfig BankVaultsConfig
-	jsonData, _ := json.Marshal(vaultConfig.Spec.ExternalConfig)
-	err = json.Unmarshal(jsonData, &bvConfig)
+	finalized := &corev1.ServiceAccountList{}
+	err = r.Client.List(context.TODO(), finalized, client.MatchingFields{finalizersField: vaultConfigurationFinalizer})
 	if err != nil {
-		return reconcile.Result{}, err
+		return nil, err
+	}
+	serviceAccounts := annotated.Items
+	seen := map[types.NamespacedName]bool{}
+	for _, sa := range annotated.Items {
+		seen[types.NamespacedName{Name: sa.ObjectMeta.Name, Namespace: sa.ObjectMeta.Namespace}] = true
+	}
+	for _, sa := range finalized.Items {
+		if !seen[types.NamespacedName{Name: sa.ObjectMeta.Name, Namespace: sa.ObjectMeta.Namespace}] {
+			serviceAccounts = append(serviceAccounts, sa)
+		}
+	}
+	return serviceAccounts, nil
+}
+
+// serviceAccountError is returned when the ServiceAccounts with a given name can't be applied to the
+// Vault configuration because of their own annotations or the operator configuration. It doesn't
+// prevent the rest of the ServiceAccounts from being applied, and the existing entries for that name
+// are left as they are.
+type serviceAccountError struct {
+	reason string
+	err    error
+}
+
+func (e *serviceAccountError) Error() string {
+	return e.err.Error()
+}
+
+func (e *serviceAccountError) Unwrap() error {
+	return e.err
+}
+
+func (r *ServiceAccountReconciler) reportServiceAccountError(serviceAccounts []corev1.ServiceAccount, saErr *serviceAccountError) {
+	var templateErr *policyTemplateError
+	isTemplateErr := errors.As(saErr, &templateErr)
+	for _, sa := range serviceAccounts {
+		if isTemplateErr {
+			log.Error(saErr, "Error rendering policy template", "ServiceAccount", sa.ObjectMeta.Name, "Namespace", sa.ObjectMeta.Namespace, "Template", templateErr.template)
+		} else {
+			log.Error(saErr, "Can't apply ServiceAccount to Vault configuration", "ServiceAccount", sa.ObjectMeta.Name, "Namespace", sa.ObjectMeta.Namespace)
+		}
+		r.Recorder.Event(&sa, corev1.EventTypeWarning, saErr.reason, saErr.Error())
+		if !isTemplateErr {
+			continue
+		}
+		if source := getConfigurationSource(r.Client); source != nil {
+			r.Recorder.Event(source, corev1.EventTypeWarning, saErr.reason, fmt.Sprintf("%s (ServiceAccount %s/%s)", saErr.Error(), sa.ObjectMeta.Namespace, sa.ObjectMeta.Name))
+		}
 	}
-	configMap := &corev1.ConfigMap{}
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: "vault-dynamic-configuration", Namespace: "vault"}, configMap)
+}
+
+// applyServiceAccounts adds or updates the role, policies and database role called name for the
+// ServiceAccounts that share it. The role is bound to all of their names and namespaces, and the rest of
+// the settings are taken from the annotations of the first one (sorted by namespace). bvConfig and entries
+// may be partially changed when a *serviceAccountError is returned, so it should be called on copies of
+// them that are discarded in that case.
+func applyServiceAccounts(bvConfig *BankVaultsConfig, entries *managedEntries, config vaultv1alpha1.VaultDynamicConfigurationSpec, name string, serviceAccounts []corev1.ServiceAccount) error {
+	metadata := serviceAccounts[0].ObjectMeta
+	policyTemplates := splitAnnotationList(metadata.Annotations[AnnotationPrefix+"/"+policyTemplatesAnnotation])
+	for _, t := range policyTemplates {
+		if _, ok := config.PolicyTemplates[t]; !ok {
+			return &serviceAccountError{reason: "PolicyTemplateNotFound", err: fmt.Errorf("Policy template %s not found in the operator configuration", t)}
+		}
+	}
+	tokenSettings, err := tokenSettingsFromAnnotations(metadata.Annotations)
 	if err != nil {
-		reqLogger.V(1).Info("vault-dynamic-configuration ConfigMap not found, using defaults")
+		return &serviceAccountError{reason: "InvalidAnnotation", err: err}
+	}
+	attachedPolicies := []string{}
+	for _, p := range splitAnnotationList(metadata.Annotations[AnnotationPrefix+"/"+policiesAnnotation]) {
+		policyName, err := resolveAttachedPolicy(entries, config, metadata.Namespace, p)
+		if err != nil {
+			return &serviceAccountError{reason: "PolicyNotAllowed", err: err}
+		}
+		attachedPolicies = append(attachedPolicies, policyName)
+	}
+	targetDbs := []string{}
+	for _, db := range splitAnnotationList(metadata.Annotations[AnnotationPrefix+"/"+DynamicDBCredentialsAnnotation]) {
+		if !containsString(targetDbs, db) {
+			targetDbs = append(targetDbs, db)
+		}
 	}
-	err = addOrUpdatePolicy(&bvConfig, instance.ObjectMeta, *configMap)
+	dbRoles := dbRoleNames(name, targetDbs)
+	entries.claimAdopted(name)
+	err = entries.checkOwnership(*bvConfig, name, serviceAccountOwner)
 	if err != nil {
-		return reconcile.Result{}, err
+		return &serviceAccountError{reason: "NameCollision", err: err}
 	}
-	kubernetesAuth, err := bvConfig.getKubernetesAuth()
+	for _, t := range policyTemplates {
+		err = entries.checkPolicyOwnership(*bvConfig, templatePolicyName(name, t), derivedEntryOwner(name))
+		if err != nil {
+			return &serviceAccountError{reason: "NameCollision", err: err}
+		}
+	}
+	for _, r := range dbRoles {
+		err = entries.checkDBRoleOwnership(*bvConfig, r, dbRoleOwner(name, r))
+		if err != nil {
+			return &serviceAccountError{reason: "NameCollision", err: err}
+		}
+	}
+	input := newPolicyTemplateInput(metadata)
+	input.RoleName = name
+	input.DBRoles = dbRoles
+	input.DatabaseMountPath = bvConfig.getDBMountPath()
+	engineEntries, err := secretEngineEntriesFromAnnotations(name, metadata, input, config)
 	if err != nil {
-		return reconcile.Result{}, err
+		return err
+	}
+	engines := []secretEngine{}
+	for _, e := range engineEntries {
+		engine, err := bvConfig.getSecretEngine(e.engineType)
+		if err != nil {
+			return &serviceAccountError{reason: "SecretsEngineNotFound", err: err}
+		}
+		err = entries.checkSecretEngineEntryOwnership(engine, e, serviceAccountOwner)
+		if err != nil {
+			return &serviceAccountError{reason: "NameCollision", err: err}
+		}
+		engines = append(engines, engine)
 	}
-	addOrUpdateKubernetesRole(kubernetesAuth, instance.ObjectMeta)
-	reqLogger.V(1).Info("Added Kubernetes role")
-	err = updateKubernetesConfiguration(bvConfig, vaultConfig)
+	rules, err := renderPolicy("policyTemplate", config.PolicyTemplate, input)
 	if err != nil {
-		return reconcile.Result{}, err
+		return &serviceAccountError{reason: "PolicyTemplateError", err: err}
 	}
-	r.Client.Update(context.TODO(), vaultConfig)
-
-	targetDb, ok := instance.Annotations[AnnotationPrefix+"/"+DynamicDBCredentialsAnnotation]
-	if !ok {
-		return reconcile.Result{}, nil
+	rules = appendDBCredsStanzas(rules, input.DatabaseMountPath, dbRoles)
+	rules, err = appendKvStanzas(*bvConfig, rules, metadata.Annotations, input, config)
+	if err != nil {
+		return err
+	}
+	engineEntryKeys := []string{}
+	for i, e := range engineEntries {
+		for _, path := range e.paths {
+			rules = appendPathStanza(rules, fmt.Sprintf("%s/%s", engines[i].mountPath(), path), e.capabilities...)
+		}
+		engines[i].upsertEntry(secretEngineEntryLists[e.engineType], e.fields)
+		key := secretEngineEntryKey(e.engineType, e.name())
+		entries.SecretEngineEntries[key] = serviceAccountOwner
+		engineEntryKeys = append(engineEntryKeys, key)
+	}
+	for key, o := range entries.SecretEngineEntries {
+		if _, n := splitSecretEngineEntryKey(key); o == serviceAccountOwner && n == name && !containsString(engineEntryKeys, key) {
+			removeSecretEngineEntry(bvConfig, key)
+			delete(entries.SecretEngineEntries, key)
+		}
+	}
+	for i, targetDb := range targetDbs {
+		err = addOrUpdateDBRole(bvConfig, dbRoles[i], metadata, input, config, targetDb)
+		if err != nil {
+			return err
+		}
+		entries.DBRoles[dbRoles[i]] = dbRoleOwner(name, dbRoles[i])
 	}
-	err = addOrUpdateDBRole(&bvConfig, instance.ObjectMeta, *configMap, targetDb)
+	for r, o := range entries.DBRoles {
+		if (o == derivedEntryOwner(name) || (o == serviceAccountOwner && r == name)) && !containsString(dbRoles, r) {
+			removeDBRole(bvConfig, r)
+			delete(entries.DBRoles, r)
+		}
+	}
+	upsertPolicy(bvConfig, name, rules)
+	entries.Policies[name] = serviceAccountOwner
+	templatePolicies, err := addOrUpdateTemplatePolicies(bvConfig, entries, name, input, config, policyTemplates)
 	if err != nil {
-		return reconcile.Result{}, err
+		return &serviceAccountError{reason: "PolicyTemplateError", err: err}
 	}
-	err = updateDBSecretConfiguration(bvConfig, vaultConfig)
+	kubernetesAuth, err := bvConfig.getKubernetesAuth()
 	if err != nil {
-		return reconcile.Result{}, err
+		return err
+	}
+	tokenPolicies := append([]string{name}, templatePolicies...)
+	for _, p := range attachedPolicies {
+		if !containsString(tokenPolicies, p) {
+			tokenPolicies = append(tokenPolicies, p)
+		}
+	}
+	serviceAccountNames := []string{}
+	namespaces := []string{}
+	for _, sa := range serviceAccounts {
+		if !containsString(serviceAccountNames, sa.ObjectMeta.Name) {
+			serviceAccountNames = append(serviceAccountNames, sa.ObjectMeta.Name)
+		}
+		if !containsString(namespaces, sa.ObjectMeta.Namespace) {
+			namespaces = append(namespaces, sa.ObjectMeta.Namespace)
+		}
+	}
+	if BoundRolesToAllNamespaces {
+		namespaces = []string{"*"}
+	}
+	role := Role{
+		BoundServiceAccountNames:      strings.Join(serviceAccountNames, ","),
+		BoundServiceAccountNamespaces: namespaces,
+		Name:                          name,
+		TokenPolicies:                 tokenPolicies,
 	}
-	r.Client.Update(context.TODO(), vaultConfig)
-	return reconcile.Result{}, nil
+	role.setTokenSettings(mergeTokenSettings(tokenSettings, config.RoleDefaults))
+	upsertRole(kubernetesAuth, role)
+	entries.Roles[name] = serviceAccountOwner
+	return nil
+}
+
+// pruneServiceAccountEntries removes the roles, policies and database roles created for ServiceAccounts
+// that are no longer annotated (or that were deleted).
+func pruneServiceAccountEntries(bvConfig *BankVaultsConfig, entries *managedEntries, desired map[string][]corev1.ServiceAccount) error {
+	kubernetesAuth, err := bvConfig.getKubernetesAuth()
+	if err != nil {
+		return err
+	}
+	for name, o := range entries.Roles {
+		if o == serviceAccountOwner && desired[name] == nil {
+			removeRole(kubernetesAuth, name)
+			delete(entries.Roles, name)
+		}
+	}
+	for name, o := range entries.Policies {
+		if o == serviceAccountOwner && desired[name] == nil {
+			removePolicy(bvConfig, name)
+			delete(entries.Policies, name)
+		}
+		if strings.HasPrefix(o, serviceAccountOwner+"/") && desired[strings.TrimPrefix(o, serviceAccountOwner+"/")] == nil {
+			removePolicy(bvConfig, name)
+			delete(entries.Policies, name)
+		}
+	}
+	for name, o := range entries.DBRoles {
+		if o == serviceAccountOwner && desired[name] == nil {
+			removeDBRole(bvConfig, name)
+			delete(entries.DBRoles, name)
+		}
+		if strings.HasPrefix(o, serviceAccountOwner+"/") && desired[strings.TrimPrefix(o, serviceAccountOwner+"/")] == nil {
+			removeDBRole(bvConfig, name)
+			delete(entries.DBRoles, name)
+		}
+	}
+	for key, o := range entries.SecretEngineEntries {
+		if _, n := splitSecretEngineEntryKey(key); o == serviceAccountOwner && desired[n] == nil {
+			removeSecretEngineEntry(bvConfig, key)
+			delete(entries.SecretEngineEntries, key)
+		}
+	}
+	return nil
 }
 
 func (r *ServiceAccountReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	scope, err := newServiceAccountScope()
+	if err != nil {
+		return err
+	}
+	r.scope = scope
+
+	namer, err := newEntryNamer()
+	if err != nil {
+		return err
+	}
+	r.namer = namer
+
+	err = mgr.GetFieldIndexer().IndexField(context.TODO(), &corev1.ServiceAccount{}, autoConfigureField, func(o runtime.Object) []string {
+		sa := o.(*corev1.ServiceAccount)
+		if val, ok := sa.Annotations[AnnotationPrefix+"/"+AutoConfigureAnnotation]; ok {
+			return []string{val}
+		}
+		return []string{}
+	})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.TODO(), &corev1.ServiceAccount{}, finalizersField, func(o runtime.Object) []string {
+		return o.(*corev1.ServiceAccount).ObjectMeta.Finalizers
+	})
+	if err != nil {
+		return err
+	}
+
 	c, err := controller.New("serviceaccount-controller", mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
 
-	err = c.Watch(&source.Kind{Type: &corev1.ServiceAccount{}}, &handler.EnqueueRequestForObject{})
+	err = c.Watch(&source.Kind{Type: &corev1.ServiceAccount{}}, &debouncedHandler{window: DebounceWindow}, r.scope.predicate(mgr.GetClient()))
+	if err != nil {
+		return err
+	}
+
+	if !r.scope.namespaceSelector.Empty() {
+		err = c.Watch(&source.Kind{Type: &corev1.Namespace{}}, &debouncedHandler{window: DebounceWindow})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Watch(&source.Kind{Type: &bankvaultsv1alpha1.Vault{}}, &debouncedHandler{window: DebounceWindow}, targetVaultPredicate())
 	if err != nil {
 		return err
 	}
 
-	err = c.Watch(&source.Kind{
-		Type: &bankvaultsv1alpha1.Vault{}},
-		&handler.EnqueueRequestsFromMapFunc{
-			ToRequests: handler.ToRequestsFunc(func(h handler.MapObject) []reconcile.Request {
-				return getRequestsForAllAnnotatedServiceAccounts(mgr)
-			}),
-		},
-	)
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &debouncedHandler{window: DebounceWindow}, configurationConfigMapPredicate())
 	if err != nil {
 		return err
 	}
 
-	err = c.Watch(&source.Kind{
-		Type: &corev1.ConfigMap{}},
-		&handler.EnqueueRequestsFromMapFunc{
-			ToRequests: handler.ToRequestsFunc(func(h handler.MapObject) []reconcile.Request {
-				return getRequestsForAllAnnotatedServiceAccounts(mgr)
-			}),
-		},
-	)
+	err = c.Watch(&source.Kind{Type: &vaultv1alpha1.VaultDynamicConfiguration{}}, &debouncedHandler{window: DebounceWindow}, configurationPredicate())
 	if err != nil {
 		return err
 	}
@@ -255,140 +687,403 @@ func getOperatorNamespace() (string, error) {
 	return ns, nil
 }
 
-func getRequestsForAllAnnotatedServiceAccounts(mgr manager.Manager) []reconcile.Request {
-	namespaces := &corev1.NamespaceList{}
-	mgr.GetClient().List(context.TODO(), namespaces)
-	requests := []reconcile.Request{}
-	for _, ns := range namespaces.Items {
-		serviceAccounts := &corev1.ServiceAccountList{}
-		mgr.GetClient().List(context.TODO(), serviceAccounts, client.InNamespace(ns.ObjectMeta.Name))
-		for _, sa := range serviceAccounts.Items {
-			if val, ok := sa.ObjectMeta.Annotations[AnnotationPrefix+"/"+AutoConfigureAnnotation]; ok {
-				if val == "true" {
-					requests = append(requests, reconcile.Request{
-						NamespacedName: types.NamespacedName{
-							Name:      sa.ObjectMeta.Name,
-							Namespace: sa.ObjectMeta.Namespace,
-						},
-					})
-				}
-			}
+func splitAnnotationList(val string) []string {
+	list := []string{}
+	for _, e := range strings.Split(val, ",") {
+		e = strings.TrimSpace(e)
+		if e != "" {
+			list = append(list, e)
 		}
 	}
-	return requests
+	return list
 }
 
-func addOrUpdateDBRole(bvConfig *BankVaultsConfig, metadata metav1.ObjectMeta, configMap corev1.ConfigMap, targetDb string) error {
-	var creationStatement string
-	if val, ok := configMap.Data["db-user-creation-statement"]; !ok {
-		creationStatement = defaultDynamicDBUserCreationStatement
-	} else {
-		creationStatement = val
+// tokenSettingsFromAnnotations parses the token settings set on a ServiceAccount's annotations,
+// and returns an error naming the first annotation with an invalid value.
+func tokenSettingsFromAnnotations(annotations map[string]string) (vaultv1alpha1.TokenSettings, error) {
+	settings := vaultv1alpha1.TokenSettings{}
+	durations := []struct {
+		annotation string
+		field      *string
+	}{
+		{tokenTtlAnnotation, &settings.TokenTtl},
+		{tokenMaxTtlAnnotation, &settings.TokenMaxTtl},
+		{tokenExplicitMaxTtlAnnotation, &settings.TokenExplicitMaxTtl},
+		{tokenPeriodAnnotation, &settings.TokenPeriod},
 	}
-
-	var dbDefaultTtl string
-	if val, ok := configMap.Data["db-default-ttl"]; !ok {
-		dbDefaultTtl = defaultDbDefaultTtl
-	} else {
-		dbDefaultTtl = val
+	for _, d := range durations {
+		val := strings.TrimSpace(annotations[AnnotationPrefix+"/"+d.annotation])
+		err := validateDuration(AnnotationPrefix+"/"+d.annotation, val)
+		if err != nil {
+			return settings, err
+		}
+		*d.field = val
+	}
+	if val, ok := annotations[AnnotationPrefix+"/"+tokenBoundCidrsAnnotation]; ok {
+		settings.TokenBoundCidrs = splitAnnotationList(val)
+		err := validateCidrs(AnnotationPrefix+"/"+tokenBoundCidrsAnnotation, settings.TokenBoundCidrs)
+		if err != nil {
+			return settings, err
+		}
+	}
+	if val, ok := annotations[AnnotationPrefix+"/"+tokenNoDefaultPolicyAnnotation]; ok {
+		noDefaultPolicy, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return settings, fmt.Errorf("%s/%s: invalid boolean %s", AnnotationPrefix, tokenNoDefaultPolicyAnnotation, val)
+		}
+		settings.TokenNoDefaultPolicy = noDefaultPolicy
 	}
+	if val, ok := annotations[AnnotationPrefix+"/"+tokenNumUsesAnnotation]; ok {
+		numUses, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil || numUses < 0 {
+			return settings, fmt.Errorf("%s/%s: invalid number %s", AnnotationPrefix, tokenNumUsesAnnotation, val)
+		}
+		settings.TokenNumUses = numUses
+	}
+	settings.TokenType = strings.TrimSpace(annotations[AnnotationPrefix+"/"+tokenTypeAnnotation])
+	return settings, validateTokenType(AnnotationPrefix+"/"+tokenTypeAnnotation, settings.TokenType)
+}
+
+// targetVaultPredicate filters events for the Vault CR the operator configures.
+func targetVaultPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(m metav1.Object, o runtime.Object) bool {
+		ns, _ := getOperatorNamespace()
+		return m.GetName() == TargetVaultName && m.GetNamespace() == ns
+	})
+}
 
-	var dbMaxTtl string
-	if val, ok := configMap.Data["db-max-ttl"]; !ok {
-		dbMaxTtl = defaultDbMaxTtl
-	} else {
-		dbMaxTtl = val
+func getVaultConfig(c client.Client) (*bankvaultsv1alpha1.Vault, BankVaultsConfig, error) {
+	vaultConfig := &bankvaultsv1alpha1.Vault{}
+	var bvConfig BankVaultsConfig
+	ns, _ := getOperatorNamespace()
+	err := c.Get(context.TODO(), types.NamespacedName{Name: TargetVaultName, Namespace: ns}, vaultConfig)
+	if err != nil {
+		return nil, bvConfig, err
 	}
+	jsonData, _ := json.Marshal(vaultConfig.Spec.ExternalConfig)
+	err = json.Unmarshal(jsonData, &bvConfig)
+	if err != nil {
+		return vaultConfig, bvConfig, err
+	}
+	bvConfig.engines, err = getSecretEngines(jsonData)
+	return vaultConfig, bvConfig, err
+}
 
-	dbSecret, err := bvConfig.GetDBSecret()
+// deepCopy returns a copy of the configuration (including the raw view of its secrets engines) that can
+// be changed without affecting the original.
+func (bvConfig BankVaultsConfig) deepCopy() (BankVaultsConfig, error) {
+	var c BankVaultsConfig
+	jsonData, err := json.Marshal(bvConfig)
 	if err != nil {
-		return err
+		return c, err
 	}
-	for _, r := range dbSecret.Configuration.Roles {
-		if r.Name == metadata.Name {
+	err = json.Unmarshal(jsonData, &c)
+	if err != nil {
+		return c, err
+	}
+	jsonData, err = json.Marshal(bvConfig.engines)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(jsonData, &c.engines)
+	return c, err
+}
+
+// updateVaultConfig applies a change to the Vault CR's external configuration and to the ledger of
+// managed entries, and writes both back in a single update, unless nothing changed. If the CR was modified since it was
+// read, the change is applied again to the fresh state. Errors returned by mutate abort the update.
+func updateVaultConfig(c client.Client, mutate func(bvConfig *BankVaultsConfig, entries *managedEntries) error) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		vaultConfig, bvConfig, err := getVaultConfig(c)
+		if err != nil {
+			return err
+		}
+		entries, err := getManagedEntries(vaultConfig, bvConfig)
+		if err != nil {
+			return err
+		}
+		configBefore := normalizedExternalConfig(vaultConfig)
+		entriesBefore := vaultConfig.ObjectMeta.Annotations[AnnotationPrefix+"/"+managedEntriesAnnotation]
+		dbSecret, _ := bvConfig.GetDBSecret()
+		dbSecretBefore, _ := json.Marshal(dbSecret)
+		enginesBefore, _ := json.Marshal(bvConfig.engines)
+		err = mutate(&bvConfig, entries)
+		if err != nil {
+			return err
+		}
+		err = updateKubernetesConfiguration(bvConfig, vaultConfig)
+		if err != nil {
+			return err
+		}
+		dbSecret, _ = bvConfig.GetDBSecret()
+		dbSecretAfter, _ := json.Marshal(dbSecret)
+		if !bytes.Equal(dbSecretBefore, dbSecretAfter) {
+			err = updateDBSecretConfiguration(bvConfig, vaultConfig)
+			if err != nil {
+				return err
+			}
+		}
+		enginesAfter, _ := json.Marshal(bvConfig.engines)
+		if !bytes.Equal(enginesBefore, enginesAfter) {
+			err = updateSecretEnginesConfiguration(bvConfig, vaultConfig)
+			if err != nil {
+				return err
+			}
+		}
+		err = setManagedEntries(vaultConfig, entries)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(configBefore, normalizedExternalConfig(vaultConfig)) && entriesBefore == vaultConfig.ObjectMeta.Annotations[AnnotationPrefix+"/"+managedEntriesAnnotation] {
 			return nil
 		}
+		return c.Update(context.TODO(), vaultConfig)
+	})
+}
+
+// normalizedExternalConfig returns the external configuration of the Vault CR as JSON with sorted keys,
+// so it can be compared regardless of how it was formatted.
+func normalizedExternalConfig(vaultConfig *bankvaultsv1alpha1.Vault) []byte {
+	var config interface{}
+	err := json.Unmarshal([]byte(vaultConfig.Spec.ExternalConfigJSON()), &config)
+	if err != nil {
+		return nil
 	}
-	log.V(1).Info("Configuring ServiceAccount for dynamic database secrets", "ServiceAccount", metadata.Name, "Namespace", metadata.Namespace, "TargetDB", targetDb)
-	newDbRole := &DBRole{
-		Name:               metadata.Name,
-		DbName:             targetDb,
-		CreationStatements: []string{creationStatement},
-		DefaultTtl:         dbDefaultTtl,
-		MaxTtl:             dbMaxTtl,
+	jsonData, _ := json.Marshal(config)
+	return jsonData
+}
+
+// addOrUpdateDBRole converges the database role to the current annotations and operator configuration.
+// If the target connection changed, the role is moved from the 'allowed_roles' of the previous one. The
+// statements are rendered with the same input as the policy of the ServiceAccounts.
+func addOrUpdateDBRole(bvConfig *BankVaultsConfig, name string, metadata metav1.ObjectMeta, input policyTemplateInput, config vaultv1alpha1.VaultDynamicConfigurationSpec, targetDb string) error {
+	dbSecret, err := bvConfig.GetDBSecret()
+	if err != nil {
+		return &serviceAccountError{reason: "DatabaseNotFound", err: err}
 	}
 	dbConfig, err := dbSecret.Configuration.GetDBConfig(targetDb)
+	if err != nil {
+		return &serviceAccountError{reason: "DatabaseNotFound", err: err}
+	}
+	defaultTtl, maxTtl, err := dbTtlsFromAnnotations(metadata.Annotations, config)
+	if err != nil {
+		return &serviceAccountError{reason: "InvalidAnnotation", err: err}
+	}
+	statements, err := statementsForPlugin(dbConfig.PluginName, metadata.Annotations[AnnotationPrefix+"/"+dbProfileAnnotation], config)
+	if err != nil {
+		return err
+	}
+	statements, err = renderStatements(statements, input)
 	if err != nil {
 		return err
 	}
-	dbConfig.AllowedRoles = append(dbConfig.AllowedRoles, metadata.Name)
-	dbSecret.Configuration.Roles = append(dbSecret.Configuration.Roles, *newDbRole)
+	dbRole := DBRole{
+		Name:                 name,
+		DbName:               targetDb,
+		CreationStatements:   statements.CreationStatements,
+		RevocationStatements: statements.RevocationStatements,
+		RenewStatements:      statements.RenewStatements,
+		RollbackStatements:   statements.RollbackStatements,
+		DefaultTtl:           defaultTtl,
+		MaxTtl:               maxTtl,
+	}
+	for i, c := range dbSecret.Configuration.Config {
+		if c.Name != targetDb && containsString(c.AllowedRoles, name) {
+			dbSecret.Configuration.Config[i].AllowedRoles = removeString(c.AllowedRoles, name)
+		}
+	}
+	if !containsString(dbConfig.AllowedRoles, name) {
+		dbConfig.AllowedRoles = append(dbConfig.AllowedRoles, name)
+	}
+	for i, r := range dbSecret.Configuration.Roles {
+		if r.Name == name {
+			if !reflect.DeepEqual(r, dbRole) {
+				log.V(1).Info("Updating dynamic database secrets for ServiceAccount", "ServiceAccount", metadata.Name, "Namespace", metadata.Namespace, "TargetDB", targetDb, "Plugin", dbConfig.PluginName)
+				dbSecret.Configuration.Roles[i] = dbRole
+			}
+			return nil
+		}
+	}
+	log.V(1).Info("Configuring ServiceAccount for dynamic database secrets", "ServiceAccount", metadata.Name, "Namespace", metadata.Namespace, "TargetDB", targetDb, "Plugin", dbConfig.PluginName)
+	dbSecret.Configuration.Roles = append(dbSecret.Configuration.Roles, dbRole)
 	return nil
 }
 
-func addOrUpdatePolicy(bvConfig *BankVaultsConfig, metadata metav1.ObjectMeta, configMap corev1.ConfigMap) error {
-	var policyTemplate string
-	if val, ok := configMap.Data["policy-template"]; !ok {
-		policyTemplate = defaultPolicyTemplate
-	} else {
-		policyTemplate = val
+// appendDBCredsStanzas adds a stanza to the policy rules to read the credentials of each database role.
+func appendDBCredsStanzas(rules string, mountPath string, dbRoles []string) string {
+	for _, r := range dbRoles {
+		rules = appendPathStanza(rules, fmt.Sprintf("%s/creds/%s", mountPath, r), "read")
+	}
+	return rules
+}
+
+// dbTtlsFromAnnotations returns the 'default_ttl' and 'max_ttl' of a database role, which can be
+// overridden with annotations.
+func dbTtlsFromAnnotations(annotations map[string]string, config vaultv1alpha1.VaultDynamicConfigurationSpec) (string, string, error) {
+	defaultTtl := config.DbDefaultTtl
+	maxTtl := config.DbMaxTtl
+	if val, ok := annotations[AnnotationPrefix+"/"+dbDefaultTtlAnnotation]; ok {
+		err := validateDuration(AnnotationPrefix+"/"+dbDefaultTtlAnnotation, val)
+		if err != nil {
+			return "", "", err
+		}
+		defaultTtl = val
+	}
+	if val, ok := annotations[AnnotationPrefix+"/"+dbMaxTtlAnnotation]; ok {
+		err := validateDuration(AnnotationPrefix+"/"+dbMaxTtlAnnotation, val)
+		if err != nil {
+			return "", "", err
+		}
+		maxTtl = val
+	}
+	return defaultTtl, maxTtl, nil
+}
+
+// addOrUpdateTemplatePolicies renders the selected policy templates into one policy each, and removes
+// the policies rendered for the service account from templates that are no longer selected. It
+// returns the names of the rendered policies.
+func addOrUpdateTemplatePolicies(bvConfig *BankVaultsConfig, entries *managedEntries, name string, input policyTemplateInput, config vaultv1alpha1.VaultDynamicConfigurationSpec, policyTemplates []string) ([]string, error) {
+	owner := derivedEntryOwner(name)
+	policyNames := []string{}
+	for _, t := range policyTemplates {
+		policyName := templatePolicyName(name, t)
+		rules, err := renderPolicy(t, config.PolicyTemplates[t], input)
+		if err != nil {
+			return nil, err
+		}
+		upsertPolicy(bvConfig, policyName, rules)
+		entries.Policies[policyName] = owner
+		policyNames = append(policyNames, policyName)
+	}
+	for name, o := range entries.Policies {
+		if o == owner && !containsString(policyNames, name) {
+			removePolicy(bvConfig, name)
+			delete(entries.Policies, name)
+		}
+	}
+	return policyNames, nil
+}
+
+func templatePolicyName(name string, policyTemplate string) string {
+	return fmt.Sprintf("%s-%s", name, policyTemplate)
+}
+
+// derivedEntryOwner is the owner recorded in the ledger for the entries whose names are derived from
+// the given name, i.e. the policies rendered from the policy templates selected by the service accounts,
+// and their database roles for multiple connections.
+func derivedEntryOwner(name string) string {
+	return fmt.Sprintf("%s/%s", serviceAccountOwner, name)
+}
+
+// dbRoleNames returns the names of the database roles for the given connections. A single connection
+// gets a database role with the same name as the role, and multiple connections get one named
+// '<name>-<connection>' each.
+func dbRoleNames(name string, targetDbs []string) []string {
+	if len(targetDbs) == 1 {
+		return []string{name}
+	}
+	dbRoles := []string{}
+	for _, db := range targetDbs {
+		dbRoles = append(dbRoles, fmt.Sprintf("%s-%s", name, db))
+	}
+	return dbRoles
+}
+
+func dbRoleOwner(name string, dbRole string) string {
+	if dbRole == name {
+		return serviceAccountOwner
+	}
+	return derivedEntryOwner(name)
+}
+
+// newPolicyTemplate returns an empty policy template with the Sprig functions available. Referencing
+// a missing label or annotation is an error, instead of rendering "<no value>" into the policy.
+func newPolicyTemplate(name string) *template.Template {
+	return template.New(name).Funcs(sprig.TxtFuncMap()).Option("missingkey=error")
+}
+
+// policyTemplateError is returned when a policy template can't be parsed or rendered.
+type policyTemplateError struct {
+	name     string
+	template string
+	err      error
+}
+
+func (e *policyTemplateError) Error() string {
+	return fmt.Sprintf("Error rendering policy template %s: %v", e.name, e.err)
+}
+
+func (e *policyTemplateError) Unwrap() error {
+	return e.err
+}
+
+func newPolicyTemplateInput(metadata metav1.ObjectMeta) policyTemplateInput {
+	return policyTemplateInput{
+		Name:        metadata.Name,
+		Namespace:   metadata.Namespace,
+		Labels:      metadata.Labels,
+		Annotations: metadata.Annotations,
+		ClusterName: ClusterName,
+		VaultName:   TargetVaultName,
+		RoleName:    metadata.Name,
+	}
+}
+
+func renderPolicy(name string, policyTemplate string, input policyTemplateInput) (string, error) {
+	t, err := newPolicyTemplate(name).Parse(policyTemplate)
+	if err != nil {
+		return "", &policyTemplateError{name: name, template: policyTemplate, err: err}
 	}
-	t := template.Must(template.New("policy").Parse(policyTemplate))
 	var parsedBuffer bytes.Buffer
-	t.Execute(&parsedBuffer, policyTemplateInput{
-		Name:      metadata.Name,
-		Namespace: metadata.Namespace,
-	})
+	err = t.Execute(&parsedBuffer, input)
+	if err != nil {
+		return "", &policyTemplateError{name: name, template: policyTemplate, err: err}
+	}
+	return parsedBuffer.String(), nil
+}
+
+func upsertPolicy(bvConfig *BankVaultsConfig, name string, rules string) {
 	for i, r := range bvConfig.Policies {
-		if r.Name == metadata.Name {
-			bvConfig.Policies[i].Rules = parsedBuffer.String()
-			return nil
+		if r.Name == name {
+			bvConfig.Policies[i].Rules = rules
+			return
 		}
 	}
 	newPolicy := &Policy{
-		Name:  metadata.Name,
-		Rules: parsedBuffer.String(),
+		Name:  name,
+		Rules: rules,
 	}
 	bvConfig.Policies = append(bvConfig.Policies, *newPolicy)
-	return nil
 }
 
-func addOrUpdateKubernetesRole(kubernetesAuth *Auth, metadata metav1.ObjectMeta) {
-	for i, r := range kubernetesAuth.Roles {
-		if r.Name == metadata.Name {
-			if BoundRolesToAllNamespaces {
-				kubernetesAuth.Roles[i].BoundServiceAccountNamespaces = []string{"*"}
-			} else {
-				switch r.BoundServiceAccountNamespaces.(type) {
-				case string:
-					kubernetesAuth.Roles[i].BoundServiceAccountNamespaces = []string{metadata.Name}
-				case []interface{}:
-					for _, n := range r.BoundServiceAccountNamespaces.([]interface{}) {
-						if n.(string) == metadata.Namespace {
-							return
-						}
-					}
-					kubernetesAuth.Roles[i].BoundServiceAccountNamespaces = append(kubernetesAuth.Roles[i].BoundServiceAccountNamespaces.([]interface{}), metadata.Namespace)
-				}
-			}
+func removePolicy(bvConfig *BankVaultsConfig, name string) {
+	for i, p := range bvConfig.Policies {
+		if p.Name == name {
+			bvConfig.Policies = append(bvConfig.Policies[:i], bvConfig.Policies[i+1:]...)
 			return
 		}
 	}
-	log.V(1).Info("Configuring ServiceAccount for Vault authentication", "ServiceAccount", metadata.Name, "Namespace", metadata.Namespace)
-	newRole := &Role{
-		BoundServiceAccountNames: metadata.Name,
-		BoundServiceAccountNamespaces: func(namespace string) []string {
-			if BoundRolesToAllNamespaces {
-				return []string{"*"}
-			} else {
-				return []string{namespace}
+}
+
+func removeDBRole(bvConfig *BankVaultsConfig, name string) {
+	dbSecret, err := bvConfig.GetDBSecret()
+	if err != nil {
+		return
+	}
+	for i, r := range dbSecret.Configuration.Roles {
+		if r.Name == name {
+			dbSecret.Configuration.Roles = append(dbSecret.Configuration.Roles[:i], dbSecret.Configuration.Roles[i+1:]...)
+			break
+		}
+	}
+	for i, c := range dbSecret.Configuration.Config {
+		for j, a := range c.AllowedRoles {
+			if a == name {
+				dbSecret.Configuration.Config[i].AllowedRoles = append(c.AllowedRoles[:j], c.AllowedRoles[j+1:]...)
+				break
 			}
-		}(metadata.Namespace),
-		Name:          metadata.Name,
-		TokenPolicies: []string{metadata.Name},
-		TokenTtl:      TokenTtl,
+		}
 	}
-	kubernetesAuth.Roles = append(kubernetesAuth.Roles, *newRole)
 }
 
 func updateDBSecretConfiguration(bvConfig BankVaultsConfig, vaultConfig *bankvaultsv1alpha1.Vault) error {
@@ -462,6 +1157,15 @@ func (bvConfig BankVaultsConfig) GetDBSecret() (*Secret, error) {
 	return &Secret{}, errors.New("Database secrets configuration not found")
 }
 
+// getDBMountPath returns the path where the database secrets engine is mounted, which defaults to its type.
+func (bvConfig BankVaultsConfig) getDBMountPath() string {
+	dbSecret, err := bvConfig.GetDBSecret()
+	if err != nil || dbSecret.Path == "" {
+		return "database"
+	}
+	return strings.Trim(dbSecret.Path, "/")
+}
+
 func (dbConfiguration DBConfiguration) GetDBConfig(targetDb string) (*DBConfig, error) {
 	for i, c := range dbConfiguration.Config {
 		if c.Name == targetDb {
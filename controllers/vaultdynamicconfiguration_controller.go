@@ -0,0 +1,424 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ConfigurationName is the name of the cluster-scoped VaultDynamicConfiguration the operator reads its
+// configuration from. It's also the name of the ConfigMap that's used as a fallback when the
+// VaultDynamicConfiguration doesn't exist.
+var ConfigurationName string
+
+const validCondition = "Valid"
+
+// VaultDynamicConfigurationReconciler reconciles a VaultDynamicConfiguration object
+type VaultDynamicConfigurationReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=vault.patoarvizu.dev,resources=vaultdynamicconfigurations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=vault.patoarvizu.dev,resources=vaultdynamicconfigurations/status,verbs=get;update;patch
+
+func (r *VaultDynamicConfigurationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.WithValues("Request.Name", req.Name)
+
+	instance := &vaultv1alpha1.VaultDynamicConfiguration{}
+	err := r.Client.Get(context.TODO(), req.NamespacedName, instance)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	err = validateConfiguration(instance.Spec)
+	if err != nil {
+		reqLogger.Error(err, "Invalid VaultDynamicConfiguration")
+		r.Recorder.Event(instance, corev1.EventTypeWarning, "InvalidConfiguration", err.Error())
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               validCondition,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.ObjectMeta.Generation,
+			Reason:             "InvalidConfiguration",
+			Message:            err.Error(),
+		})
+		return reconcile.Result{}, r.Client.Status().Update(context.TODO(), instance)
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               validCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.ObjectMeta.Generation,
+		Reason:             "Valid",
+		Message:            "Configuration is valid",
+	})
+	return reconcile.Result{}, r.Client.Status().Update(context.TODO(), instance)
+}
+
+func (r *VaultDynamicConfigurationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := controller.New("vaultdynamicconfiguration-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &vaultv1alpha1.VaultDynamicConfiguration{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getConfiguration returns the operator configuration, with defaults for the settings that aren't
+// set. The VaultDynamicConfiguration named ConfigurationName takes precedence, and an invalid one
+// is returned as an error instead of being applied. If it doesn't exist, the ConfigMap with the
+// same name in the operator's namespace is used instead, and validated the same way.
+func getConfiguration(c client.Client) (vaultv1alpha1.VaultDynamicConfigurationSpec, error) {
+	instance := &vaultv1alpha1.VaultDynamicConfiguration{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: ConfigurationName}, instance)
+	if err == nil {
+		err = validateConfiguration(instance.Spec)
+		if err != nil {
+			return vaultv1alpha1.VaultDynamicConfigurationSpec{}, fmt.Errorf("VaultDynamicConfiguration %s is invalid: %v", ConfigurationName, err)
+		}
+		return withConfigurationDefaults(*instance.Spec.DeepCopy()), nil
+	}
+	if !k8serrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return vaultv1alpha1.VaultDynamicConfigurationSpec{}, err
+	}
+	config := vaultv1alpha1.VaultDynamicConfigurationSpec{}
+	ns, _ := getOperatorNamespace()
+	configMap := &corev1.ConfigMap{}
+	err = c.Get(context.TODO(), types.NamespacedName{Name: ConfigurationName, Namespace: ns}, configMap)
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return config, err
+		}
+		log.V(1).Info("Operator configuration not found, using defaults", "Name", ConfigurationName)
+		return withConfigurationDefaults(config), nil
+	}
+	config.PolicyTemplate = configMap.Data["policy-template"]
+	config.DbUserCreationStatement = configMap.Data["db-user-creation-statement"]
+	config.DbDefaultTtl = configMap.Data["db-default-ttl"]
+	config.DbMaxTtl = configMap.Data["db-max-ttl"]
+	config = withConfigurationDefaults(config)
+	err = validateConfiguration(config)
+	if err != nil {
+		return vaultv1alpha1.VaultDynamicConfigurationSpec{}, fmt.Errorf("ConfigMap %s is invalid: %v", ConfigurationName, err)
+	}
+	return config, nil
+}
+
+// getConfigurationSource returns the object the operator configuration is read from, to report events
+// on it, or nil if the defaults are being used.
+func getConfigurationSource(c client.Client) runtime.Object {
+	instance := &vaultv1alpha1.VaultDynamicConfiguration{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: ConfigurationName}, instance)
+	if err == nil {
+		return instance
+	}
+	ns, _ := getOperatorNamespace()
+	configMap := &corev1.ConfigMap{}
+	err = c.Get(context.TODO(), types.NamespacedName{Name: ConfigurationName, Namespace: ns}, configMap)
+	if err == nil {
+		return configMap
+	}
+	return nil
+}
+
+func withConfigurationDefaults(config vaultv1alpha1.VaultDynamicConfigurationSpec) vaultv1alpha1.VaultDynamicConfigurationSpec {
+	if config.PolicyTemplate == "" {
+		config.PolicyTemplate = defaultPolicyTemplate
+	}
+	if config.DbUserCreationStatement == "" {
+		config.DbUserCreationStatement = defaultDynamicDBUserCreationStatement
+	}
+	if config.DbDefaultTtl == "" {
+		config.DbDefaultTtl = defaultDbDefaultTtl
+	}
+	if config.DbMaxTtl == "" {
+		config.DbMaxTtl = defaultDbMaxTtl
+	}
+	if config.PkiAllowedDomains == nil {
+		config.PkiAllowedDomains = defaultPkiAllowedDomains
+	}
+	if config.PkiMaxTtl == "" {
+		config.PkiMaxTtl = defaultPkiMaxTtl
+	}
+	if config.PkiKeyType == "" {
+		config.PkiKeyType = defaultPkiKeyType
+	}
+	if config.SshAllowedUsers == nil {
+		config.SshAllowedUsers = defaultSshAllowedUsers
+	}
+	if config.SshTtl == "" {
+		config.SshTtl = defaultSshTtl
+	}
+	if config.SshMaxTtl == "" {
+		config.SshMaxTtl = defaultSshMaxTtl
+	}
+	if config.KvPathTemplate == "" {
+		config.KvPathTemplate = defaultKvPathTemplate
+	}
+	kvAccess := map[string]vaultv1alpha1.KvAccess{}
+	for level, access := range defaultKvAccess {
+		kvAccess[level] = access
+	}
+	for level, access := range config.KvAccess {
+		kvAccess[level] = access
+	}
+	config.KvAccess = kvAccess
+	if config.RoleDefaults.TokenTtl == "" {
+		config.RoleDefaults.TokenTtl = TokenTtl
+	}
+	return config
+}
+
+func validateConfiguration(config vaultv1alpha1.VaultDynamicConfigurationSpec) error {
+	if config.PolicyTemplate != "" {
+		_, err := newPolicyTemplate("policy").Parse(config.PolicyTemplate)
+		if err != nil {
+			return fmt.Errorf("policyTemplate: %v", err)
+		}
+	}
+	for name, t := range config.PolicyTemplates {
+		_, err := newPolicyTemplate(name).Parse(t)
+		if err != nil {
+			return fmt.Errorf("policyTemplates.%s: %v", name, err)
+		}
+	}
+	for name, t := range config.AwsPolicyTemplates {
+		_, err := newPolicyTemplate(name).Parse(t)
+		if err != nil {
+			return fmt.Errorf("awsPolicyTemplates.%s: %v", name, err)
+		}
+	}
+	err := validateDatabasePlugins(config)
+	if err != nil {
+		return err
+	}
+	err = validateDuration("dbDefaultTtl", config.DbDefaultTtl)
+	if err != nil {
+		return err
+	}
+	err = validateDuration("dbMaxTtl", config.DbMaxTtl)
+	if err != nil {
+		return err
+	}
+	for i, t := range config.PkiAllowedDomains {
+		_, err := newPolicyTemplate(fmt.Sprintf("pkiAllowedDomains[%d]", i)).Parse(t)
+		if err != nil {
+			return fmt.Errorf("pkiAllowedDomains[%d]: %v", i, err)
+		}
+	}
+	err = validateDuration("pkiMaxTtl", config.PkiMaxTtl)
+	if err != nil {
+		return err
+	}
+	for i, t := range config.SshAllowedUsers {
+		_, err := newPolicyTemplate(fmt.Sprintf("sshAllowedUsers[%d]", i)).Parse(t)
+		if err != nil {
+			return fmt.Errorf("sshAllowedUsers[%d]: %v", i, err)
+		}
+	}
+	err = validateDuration("sshTtl", config.SshTtl)
+	if err != nil {
+		return err
+	}
+	err = validateDuration("sshMaxTtl", config.SshMaxTtl)
+	if err != nil {
+		return err
+	}
+	if config.KvPathTemplate != "" {
+		_, err := newPolicyTemplate("kvPathTemplate").Parse(config.KvPathTemplate)
+		if err != nil {
+			return fmt.Errorf("kvPathTemplate: %v", err)
+		}
+	}
+	for level, access := range config.KvAccess {
+		for _, c := range append(append(append([]string{}, access.Data...), access.Metadata...), access.Delete...) {
+			err = validateCapability(fmt.Sprintf("kvAccess.%s", level), c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	switch config.PkiKeyType {
+	case "", "rsa", "ec", "ed25519", "any":
+	default:
+		return fmt.Errorf("pkiKeyType: invalid key type %s", config.PkiKeyType)
+	}
+	return validateTokenSettings("roleDefaults.", config.RoleDefaults)
+}
+
+func validateTokenSettings(fieldPrefix string, settings vaultv1alpha1.TokenSettings) error {
+	durations := []struct {
+		field string
+		value string
+	}{
+		{"tokenTtl", settings.TokenTtl},
+		{"tokenMaxTtl", settings.TokenMaxTtl},
+		{"tokenExplicitMaxTtl", settings.TokenExplicitMaxTtl},
+		{"tokenPeriod", settings.TokenPeriod},
+	}
+	for _, d := range durations {
+		err := validateDuration(fieldPrefix+d.field, d.value)
+		if err != nil {
+			return err
+		}
+	}
+	err := validateCidrs(fieldPrefix+"tokenBoundCidrs", settings.TokenBoundCidrs)
+	if err != nil {
+		return err
+	}
+	if settings.TokenNumUses < 0 {
+		return fmt.Errorf("%stokenNumUses: must not be negative", fieldPrefix)
+	}
+	return validateTokenType(fieldPrefix+"tokenType", settings.TokenType)
+}
+
+func validateCapability(field string, capability string) error {
+	switch capability {
+	case "create", "read", "update", "patch", "delete", "list", "sudo", "deny":
+		return nil
+	}
+	return fmt.Errorf("%s: invalid capability %s", field, capability)
+}
+
+func validateCidrs(field string, cidrs []string) error {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			return fmt.Errorf("%s: invalid CIDR %s", field, cidr)
+		}
+	}
+	return nil
+}
+
+func validateTokenType(field string, tokenType string) error {
+	switch tokenType {
+	case "", "service", "batch", "default", "default-service", "default-batch":
+		return nil
+	}
+	return fmt.Errorf("%s: invalid token type %s", field, tokenType)
+}
+
+// validateDuration accepts the same formats as Vault for durations: a number of seconds, a
+// number of days with a 'd' suffix, or a Go duration string.
+func validateDuration(field string, value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if _, err := strconv.ParseUint(strings.TrimSuffix(value, "d"), 10, 64); err == nil {
+			return nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid duration %s", field, value)
+	}
+	if d < 0 {
+		return fmt.Errorf("%s: duration %s must not be negative", field, value)
+	}
+	return nil
+}
+
+// mergeTokenSettings returns the token settings, with the ones that aren't set taken from defaults.
+func mergeTokenSettings(settings vaultv1alpha1.TokenSettings, defaults vaultv1alpha1.TokenSettings) vaultv1alpha1.TokenSettings {
+	if settings.TokenTtl == "" {
+		settings.TokenTtl = defaults.TokenTtl
+	}
+	if settings.TokenMaxTtl == "" {
+		settings.TokenMaxTtl = defaults.TokenMaxTtl
+	}
+	if settings.TokenBoundCidrs == nil {
+		settings.TokenBoundCidrs = defaults.TokenBoundCidrs
+	}
+	if settings.TokenExplicitMaxTtl == "" {
+		settings.TokenExplicitMaxTtl = defaults.TokenExplicitMaxTtl
+	}
+	if !settings.TokenNoDefaultPolicy {
+		settings.TokenNoDefaultPolicy = defaults.TokenNoDefaultPolicy
+	}
+	if settings.TokenNumUses == 0 {
+		settings.TokenNumUses = defaults.TokenNumUses
+	}
+	if settings.TokenPeriod == "" {
+		settings.TokenPeriod = defaults.TokenPeriod
+	}
+	if settings.TokenType == "" {
+		settings.TokenType = defaults.TokenType
+	}
+	return settings
+}
+
+func (role *Role) setTokenSettings(settings vaultv1alpha1.TokenSettings) {
+	role.TokenTtl = settings.TokenTtl
+	role.TokenMaxTtl = settings.TokenMaxTtl
+	role.TokenBoundCidrs = settings.TokenBoundCidrs
+	role.TokenExplicitMaxTtl = settings.TokenExplicitMaxTtl
+	role.TokenNoDefaultPolicy = settings.TokenNoDefaultPolicy
+	role.TokenNumUses = settings.TokenNumUses
+	role.TokenPeriod = settings.TokenPeriod
+	role.TokenType = settings.TokenType
+}
+
+// configurationPredicate filters events for the VaultDynamicConfiguration the operator reads its
+// configuration from.
+func configurationPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(m metav1.Object, o runtime.Object) bool {
+		return m.GetName() == ConfigurationName
+	})
+}
+
+// configurationConfigMapPredicate filters events for the ConfigMap the operator falls back to when
+// the VaultDynamicConfiguration doesn't exist.
+func configurationConfigMapPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(m metav1.Object, o runtime.Object) bool {
+		ns, _ := getOperatorNamespace()
+		return m.GetName() == ConfigurationName && m.GetNamespace() == ns
+	})
+}
@@ -0,0 +1,269 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	bankvaultsv1alpha1 "github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/go-logr/logr"
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// VaultPolicyReconciler reconciles a VaultPolicy object
+type VaultPolicyReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	scope    *serviceAccountScope
+}
+
+// +kubebuilder:rbac:groups=vault.patoarvizu.dev,resources=vaultpolicies,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=vault.patoarvizu.dev,resources=vaultpolicies/status,verbs=get;update;patch
+
+func (r *VaultPolicyReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", req.Namespace, "Request.Name", req.Name)
+
+	instance := &vaultv1alpha1.VaultPolicy{}
+	err := r.Client.Get(context.TODO(), req.NamespacedName, instance)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if instance.ObjectMeta.DeletionTimestamp != nil {
+		if !controllerutil.ContainsFinalizer(instance, vaultConfigurationFinalizer) {
+			return reconcile.Result{}, nil
+		}
+		err = r.removeVaultPolicyConfiguration(instance)
+		if err != nil && !k8serrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return reconcile.Result{}, err
+		}
+		reqLogger.V(1).Info("Removed Vault configuration for VaultPolicy")
+		controllerutil.RemoveFinalizer(instance, vaultConfigurationFinalizer)
+		return reconcile.Result{}, r.Client.Update(context.TODO(), instance)
+	}
+
+	if !controllerutil.ContainsFinalizer(instance, vaultConfigurationFinalizer) {
+		controllerutil.AddFinalizer(instance, vaultConfigurationFinalizer)
+		err = r.Client.Update(context.TODO(), instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	err = r.applyVaultPolicy(instance)
+	if err != nil {
+		reason := "ApplyFailed"
+		var ownershipErr *ownershipError
+		if errors.As(err, &ownershipErr) {
+			reason = "NameCollision"
+		}
+		var specErr *specError
+		if errors.As(err, &specErr) {
+			reason = specErr.reason
+		}
+		reqLogger.Error(err, "Error applying VaultPolicy to Vault configuration")
+		r.Recorder.Event(instance, corev1.EventTypeWarning, reason, err.Error())
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               appliedCondition,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.ObjectMeta.Generation,
+			Reason:             reason,
+			Message:            err.Error(),
+		})
+		statusErr := r.Client.Status().Update(context.TODO(), instance)
+		if statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		if ownershipErr != nil || specErr != nil {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	reqLogger.V(1).Info("Applied VaultPolicy to Vault configuration")
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               appliedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.ObjectMeta.Generation,
+		Reason:             "Applied",
+		Message:            "Policy applied to the Vault configuration",
+	})
+	return reconcile.Result{}, r.Client.Status().Update(context.TODO(), instance)
+}
+
+func (r *VaultPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	scope, err := newServiceAccountScope()
+	if err != nil {
+		return err
+	}
+	r.scope = scope
+
+	c, err := controller.New("vaultpolicy-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &vaultv1alpha1.VaultPolicy{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	if !r.scope.namespaceSelector.Empty() {
+		err = c.Watch(&source.Kind{
+			Type: &corev1.Namespace{}},
+			&handler.EnqueueRequestsFromMapFunc{
+				ToRequests: handler.ToRequestsFunc(func(h handler.MapObject) []reconcile.Request {
+					return getRequestsForAllVaultPolicies(mgr)
+				}),
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Watch(&source.Kind{
+		Type: &bankvaultsv1alpha1.Vault{}},
+		&handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(h handler.MapObject) []reconcile.Request {
+				return getRequestsForAllVaultPolicies(mgr)
+			}),
+		},
+		targetVaultPredicate(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *VaultPolicyReconciler) applyVaultPolicy(instance *vaultv1alpha1.VaultPolicy) error {
+	owner := ownerKey("VaultPolicy", instance.ObjectMeta.Namespace, instance.ObjectMeta.Name)
+	name := vaultPolicyName(instance.ObjectMeta.Namespace, instance.ObjectMeta.Name)
+	err := checkNamespaceScope(r.Client, r.scope, instance.ObjectMeta.Namespace, func() error {
+		return r.removeVaultPolicyConfiguration(instance)
+	})
+	if err != nil {
+		return err
+	}
+	rules, err := renderVaultPolicy(instance)
+	if err != nil {
+		return err
+	}
+	return updateVaultConfig(r.Client, func(bvConfig *BankVaultsConfig, entries *managedEntries) error {
+		err := entries.checkPolicyOwnership(*bvConfig, name, owner)
+		if err != nil {
+			return err
+		}
+		removeOwnedPolicies(bvConfig, entries, owner, []string{name})
+		upsertPolicy(bvConfig, name, rules)
+		entries.Policies[name] = owner
+		return nil
+	})
+}
+
+func (r *VaultPolicyReconciler) removeVaultPolicyConfiguration(instance *vaultv1alpha1.VaultPolicy) error {
+	owner := ownerKey("VaultPolicy", instance.ObjectMeta.Namespace, instance.ObjectMeta.Name)
+	return updateVaultConfig(r.Client, func(bvConfig *BankVaultsConfig, entries *managedEntries) error {
+		removeOwnedPolicies(bvConfig, entries, owner, []string{})
+		return nil
+	})
+}
+
+// vaultPolicyName is the name in Vault of the policy of a VaultPolicy, or of a policy rendered for a
+// VaultRole. Namespaces can't contain dots, so policies from different namespaces can't collide.
+func vaultPolicyName(namespace string, name string) string {
+	return fmt.Sprintf("%s.%s", namespace, name)
+}
+
+// removeOwnedPolicies removes the policies recorded for the owner in the ledger, except the ones to keep
+// (the current policies of the owner), e.g. the policies created before policy names were
+// namespace-qualified are removed.
+func removeOwnedPolicies(bvConfig *BankVaultsConfig, entries *managedEntries, owner string, keep []string) {
+	for name, o := range entries.Policies {
+		if o == owner && !containsString(keep, name) {
+			removePolicy(bvConfig, name)
+			delete(entries.Policies, name)
+		}
+	}
+}
+
+// resolveAttachedPolicy returns the name in Vault of a policy that a ServiceAccount or VaultRole in the
+// given namespace attaches by name. Only the VaultPolicies in the same namespace and the policies in
+// the 'allowedPolicies' of the operator configuration can be attached, so a namespace can't grant
+// itself the policies of other namespaces or hand-written policies.
+func resolveAttachedPolicy(entries *managedEntries, config vaultv1alpha1.VaultDynamicConfigurationSpec, namespace string, name string) (string, error) {
+	qualified := vaultPolicyName(namespace, name)
+	if entries.Policies[qualified] == ownerKey("VaultPolicy", namespace, name) {
+		return qualified, nil
+	}
+	if containsString(config.AllowedPolicies, name) {
+		return name, nil
+	}
+	return "", fmt.Errorf("Policy %s is neither a VaultPolicy in namespace %s nor in the allowed policies of the operator configuration", name, namespace)
+}
+
+func renderVaultPolicy(instance *vaultv1alpha1.VaultPolicy) (string, error) {
+	if (instance.Spec.Rules == "") == (instance.Spec.Template == "") {
+		return "", &specError{reason: "InvalidSpec", err: errors.New("Exactly one of 'rules' or 'template' must be set")}
+	}
+	if instance.Spec.Rules != "" {
+		return instance.Spec.Rules, nil
+	}
+	rules, err := renderPolicy(instance.ObjectMeta.Name, instance.Spec.Template, newPolicyTemplateInput(instance.ObjectMeta))
+	if err != nil {
+		return "", &specError{reason: "InvalidSpec", err: err}
+	}
+	return rules, nil
+}
+
+func getRequestsForAllVaultPolicies(mgr manager.Manager) []reconcile.Request {
+	vaultPolicies := &vaultv1alpha1.VaultPolicyList{}
+	mgr.GetClient().List(context.TODO(), vaultPolicies)
+	requests := []reconcile.Request{}
+	for _, vp := range vaultPolicies.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      vp.ObjectMeta.Name,
+				Namespace: vp.ObjectMeta.Namespace,
+			},
+		})
+	}
+	return requests
+}
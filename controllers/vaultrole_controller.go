@@ -0,0 +1,347 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	bankvaultsv1alpha1 "github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/go-logr/logr"
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const appliedCondition = "Applied"
+
+// specError is returned when a VaultRole or VaultPolicy can't be applied because of its own spec, so
+// it's reported in its status but not retried until the spec changes.
+type specError struct {
+	reason string
+	err    error
+}
+
+func (e *specError) Error() string {
+	return e.err.Error()
+}
+
+func (e *specError) Unwrap() error {
+	return e.err
+}
+
+// VaultRoleReconciler reconciles a VaultRole object
+type VaultRoleReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	scope    *serviceAccountScope
+}
+
+// +kubebuilder:rbac:groups=vault.patoarvizu.dev,resources=vaultroles,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=vault.patoarvizu.dev,resources=vaultroles/status,verbs=get;update;patch
+
+func (r *VaultRoleReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", req.Namespace, "Request.Name", req.Name)
+
+	instance := &vaultv1alpha1.VaultRole{}
+	err := r.Client.Get(context.TODO(), req.NamespacedName, instance)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if instance.ObjectMeta.DeletionTimestamp != nil {
+		if !controllerutil.ContainsFinalizer(instance, vaultConfigurationFinalizer) {
+			return reconcile.Result{}, nil
+		}
+		err = r.removeVaultRoleConfiguration(instance)
+		if err != nil && !k8serrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return reconcile.Result{}, err
+		}
+		reqLogger.V(1).Info("Removed Vault configuration for VaultRole")
+		controllerutil.RemoveFinalizer(instance, vaultConfigurationFinalizer)
+		return reconcile.Result{}, r.Client.Update(context.TODO(), instance)
+	}
+
+	if !controllerutil.ContainsFinalizer(instance, vaultConfigurationFinalizer) {
+		controllerutil.AddFinalizer(instance, vaultConfigurationFinalizer)
+		err = r.Client.Update(context.TODO(), instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	err = r.applyVaultRole(instance)
+	if err != nil {
+		reason := "ApplyFailed"
+		var ownershipErr *ownershipError
+		if errors.As(err, &ownershipErr) {
+			reason = "NameCollision"
+		}
+		var specErr *specError
+		if errors.As(err, &specErr) {
+			reason = specErr.reason
+		}
+		reqLogger.Error(err, "Error applying VaultRole to Vault configuration")
+		r.Recorder.Event(instance, corev1.EventTypeWarning, reason, err.Error())
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               appliedCondition,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.ObjectMeta.Generation,
+			Reason:             reason,
+			Message:            err.Error(),
+		})
+		statusErr := r.Client.Status().Update(context.TODO(), instance)
+		if statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		if ownershipErr != nil || specErr != nil {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	reqLogger.V(1).Info("Applied VaultRole to Vault configuration")
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               appliedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.ObjectMeta.Generation,
+		Reason:             "Applied",
+		Message:            "Role applied to the Vault configuration",
+	})
+	return reconcile.Result{}, r.Client.Status().Update(context.TODO(), instance)
+}
+
+func (r *VaultRoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	scope, err := newServiceAccountScope()
+	if err != nil {
+		return err
+	}
+	r.scope = scope
+
+	c, err := controller.New("vaultrole-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &vaultv1alpha1.VaultRole{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	if !r.scope.namespaceSelector.Empty() {
+		err = c.Watch(&source.Kind{
+			Type: &corev1.Namespace{}},
+			&handler.EnqueueRequestsFromMapFunc{
+				ToRequests: handler.ToRequestsFunc(func(h handler.MapObject) []reconcile.Request {
+					return getRequestsForAllVaultRoles(mgr)
+				}),
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Watch(&source.Kind{
+		Type: &bankvaultsv1alpha1.Vault{}},
+		&handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(h handler.MapObject) []reconcile.Request {
+				return getRequestsForAllVaultRoles(mgr)
+			}),
+		},
+		targetVaultPredicate(),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{
+		Type: &vaultv1alpha1.VaultDynamicConfiguration{}},
+		&handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(h handler.MapObject) []reconcile.Request {
+				return getRequestsForAllVaultRoles(mgr)
+			}),
+		},
+		configurationPredicate(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *VaultRoleReconciler) applyVaultRole(instance *vaultv1alpha1.VaultRole) error {
+	owner := ownerKey("VaultRole", instance.ObjectMeta.Namespace, instance.ObjectMeta.Name)
+	err := checkNamespaceScope(r.Client, r.scope, instance.ObjectMeta.Namespace, func() error {
+		return r.removeVaultRoleConfiguration(instance)
+	})
+	if err != nil {
+		return err
+	}
+	err = validateTokenSettings("", instance.Spec.TokenSettings)
+	if err != nil {
+		return &specError{reason: "InvalidSpec", err: err}
+	}
+	config, err := getConfiguration(r.Client)
+	if err != nil {
+		return err
+	}
+	roleName := vaultRoleName(instance.ObjectMeta.Namespace, instance.ObjectMeta.Name)
+	return updateVaultConfig(r.Client, func(bvConfig *BankVaultsConfig, entries *managedEntries) error {
+		err := entries.checkRoleOwnership(*bvConfig, roleName, owner)
+		if err != nil {
+			return err
+		}
+		policyNames := []string{}
+		templatedPolicies := map[string]string{}
+		// The templated policies are added in the order of the spec, so the Vault CR only changes
+		// when they do.
+		templatedPolicyNames := []string{}
+		for _, p := range instance.Spec.Policies {
+			policyName := vaultPolicyName(instance.ObjectMeta.Namespace, p.Name)
+			if p.Template == "" {
+				policyName, err = resolveAttachedPolicy(entries, config, instance.ObjectMeta.Namespace, p.Name)
+				if err != nil {
+					return &specError{reason: "PolicyNotAllowed", err: err}
+				}
+			} else {
+				err = entries.checkPolicyOwnership(*bvConfig, policyName, owner)
+				if err != nil {
+					return err
+				}
+				rules, err := renderPolicy(p.Name, p.Template, newPolicyTemplateInput(instance.ObjectMeta))
+				if err != nil {
+					return &specError{reason: "InvalidSpec", err: err}
+				}
+				if _, ok := templatedPolicies[policyName]; !ok {
+					templatedPolicyNames = append(templatedPolicyNames, policyName)
+				}
+				templatedPolicies[policyName] = rules
+			}
+			if !containsString(policyNames, policyName) {
+				policyNames = append(policyNames, policyName)
+			}
+		}
+		for _, name := range templatedPolicyNames {
+			upsertPolicy(bvConfig, name, templatedPolicies[name])
+			entries.Policies[name] = owner
+		}
+		removeOwnedPolicies(bvConfig, entries, owner, templatedPolicyNames)
+		kubernetesAuth, err := bvConfig.getKubernetesAuth()
+		if err != nil {
+			return err
+		}
+		// Roles created before role names were namespace-qualified are removed.
+		for name, o := range entries.Roles {
+			if o == owner && name != roleName {
+				removeRole(kubernetesAuth, name)
+				delete(entries.Roles, name)
+			}
+		}
+		upsertRole(kubernetesAuth, vaultRoleToRole(instance, roleName, policyNames, config.RoleDefaults))
+		entries.Roles[roleName] = owner
+		return nil
+	})
+}
+
+func (r *VaultRoleReconciler) removeVaultRoleConfiguration(instance *vaultv1alpha1.VaultRole) error {
+	owner := ownerKey("VaultRole", instance.ObjectMeta.Namespace, instance.ObjectMeta.Name)
+	return updateVaultConfig(r.Client, func(bvConfig *BankVaultsConfig, entries *managedEntries) error {
+		kubernetesAuth, err := bvConfig.getKubernetesAuth()
+		if err != nil {
+			return err
+		}
+		for name, o := range entries.Roles {
+			if o == owner {
+				removeRole(kubernetesAuth, name)
+				delete(entries.Roles, name)
+			}
+		}
+		removeOwnedPolicies(bvConfig, entries, owner, []string{})
+		return nil
+	})
+}
+
+func getRequestsForAllVaultRoles(mgr manager.Manager) []reconcile.Request {
+	vaultRoles := &vaultv1alpha1.VaultRoleList{}
+	mgr.GetClient().List(context.TODO(), vaultRoles)
+	requests := []reconcile.Request{}
+	for _, vr := range vaultRoles.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      vr.ObjectMeta.Name,
+				Namespace: vr.ObjectMeta.Namespace,
+			},
+		})
+	}
+	return requests
+}
+
+// vaultRoleName is the name in Vault of the role of a VaultRole, qualified with its namespace the same
+// way as policies, so VaultRoles with the same name in different namespaces don't collide.
+func vaultRoleName(namespace string, name string) string {
+	return vaultPolicyName(namespace, name)
+}
+
+func vaultRoleToRole(instance *vaultv1alpha1.VaultRole, name string, policyNames []string, roleDefaults vaultv1alpha1.TokenSettings) Role {
+	role := Role{
+		BoundServiceAccountNames:      strings.Join(instance.Spec.ServiceAccounts, ","),
+		BoundServiceAccountNamespaces: []string{instance.ObjectMeta.Namespace},
+		Name:                          name,
+		TokenPolicies:                 policyNames,
+	}
+	role.setTokenSettings(mergeTokenSettings(instance.Spec.TokenSettings, roleDefaults))
+	return role
+}
+
+func upsertRole(kubernetesAuth *Auth, role Role) {
+	for i, r := range kubernetesAuth.Roles {
+		if r.Name == role.Name {
+			kubernetesAuth.Roles[i] = role
+			return
+		}
+	}
+	kubernetesAuth.Roles = append(kubernetesAuth.Roles, role)
+}
+
+func removeRole(kubernetesAuth *Auth, name string) {
+	for i, r := range kubernetesAuth.Roles {
+		if r.Name == name {
+			kubernetesAuth.Roles = append(kubernetesAuth.Roles[:i], kubernetesAuth.Roles[i+1:]...)
+			return
+		}
+	}
+}
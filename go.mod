@@ -3,6 +3,7 @@ module github.com/patoarvizu/vault-dynamic-configuration-operator
 go 1.16
 
 require (
+	github.com/Masterminds/sprig/v3 v3.1.0
 	github.com/banzaicloud/bank-vaults v1.14.3-0.20211011063455-e2138a966538
 	github.com/go-logr/logr v0.4.0
 	github.com/onsi/ginkgo v1.15.0
@@ -20,6 +20,7 @@ import (
 	"encoding/gob"
 	"flag"
 	"os"
+	"time"
 
 	bankvaultsv1alpha1 "github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
@@ -29,6 +30,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
 	"github.com/patoarvizu/vault-dynamic-configuration-operator/controllers"
 	// +kubebuilder:scaffold:imports
 )
@@ -42,6 +44,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(corev1.AddToScheme(scheme))
+	utilruntime.Must(vaultv1alpha1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -57,7 +60,17 @@ func main() {
 	flag.StringVar(&controllers.AutoConfigureAnnotation, "auto-configure-annotation", "auto-configure", "Annotation the operator should watch for in service accounts")
 	flag.StringVar(&controllers.DynamicDBCredentialsAnnotation, "auto-configuredb-creds-annotation", "db-dynamic-creds", "Annotation the operator should watch for in service accounts to configure access to dynamic DB credentials")
 	flag.BoolVar(&controllers.BoundRolesToAllNamespaces, "bound-roles-to-all-namespaces", false, "Set 'bound_service_account_namespaces' to '*' instead of the service account's namespace")
-	flag.StringVar(&controllers.TokenTtl, "token-ttl", "5m", "Value to set roles' 'token_ttl' to")
+	flag.StringVar(&controllers.TokenTtl, "token-ttl", "5m", "Value to set roles' 'token_ttl' to, unless it's set in the role defaults of the operator configuration")
+	flag.StringVar(&controllers.ClusterName, "cluster-name", "", "Name of the cluster, available to policy templates as '.ClusterName'")
+	flag.StringVar(&controllers.ConfigurationName, "configuration-name", "vault-dynamic-configuration", "Name of the VaultDynamicConfiguration custom resource (or ConfigMap in the operator's namespace, as a fallback) to read the operator configuration from")
+	flag.DurationVar(&controllers.DebounceWindow, "debounce-window", 5*time.Second, "Time to wait after a change to a service account (or to the Vault or operator configuration) before updating the Vault configuration, so that changes made in bursts are applied together")
+	flag.StringVar(&controllers.IncludeNamespaces, "include-namespaces", "", "Comma-separated list of namespaces where annotated service accounts are configured. If empty, all namespaces are included")
+	flag.StringVar(&controllers.ExcludeNamespaces, "exclude-namespaces", "kube-system,kube-public,kube-node-lease", "Comma-separated list of namespaces where annotated service accounts are ignored")
+	flag.StringVar(&controllers.NamespaceSelector, "namespace-selector", "", "Label selector that the namespace of annotated service accounts must match for them to be configured")
+	flag.StringVar(&controllers.ServiceAccountSelector, "service-account-selector", "", "Label selector that annotated service accounts must match to be configured")
+	flag.StringVar(&controllers.NamingStrategy, "naming-strategy", "name", "How roles, policies and database roles are named after service accounts. One of 'name', 'namespace-name' or 'template'")
+	flag.StringVar(&controllers.NamingTemplate, "naming-template", "", "Go template used to name roles, policies and database roles when --naming-strategy is 'template'")
+	flag.BoolVar(&controllers.KeepLegacyNames, "keep-legacy-names", false, "Keep the roles, policies and database roles named after the service account name alone, in addition to the ones named with --naming-strategy, while migrating to a different naming strategy")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(false)))
@@ -82,13 +95,41 @@ func main() {
 	}
 
 	if err = (&controllers.ServiceAccountReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ServiceAccount"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ServiceAccount"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("vault-dynamic-configuration-operator"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ServiceAccount")
 		os.Exit(1)
 	}
+	if err = (&controllers.VaultRoleReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("VaultRole"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("vault-dynamic-configuration-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VaultRole")
+		os.Exit(1)
+	}
+	if err = (&controllers.VaultPolicyReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("VaultPolicy"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("vault-dynamic-configuration-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VaultPolicy")
+		os.Exit(1)
+	}
+	if err = (&controllers.VaultDynamicConfigurationReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("VaultDynamicConfiguration"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("vault-dynamic-configuration-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VaultDynamicConfiguration")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -1,6 +1,6 @@
 resource kubernetes_config_map_v1 configmap {
   metadata {
-    name = "vault-dynamic-configuration"
+    name = var.flag_configuration_name
     namespace = var.create_namespace ? kubernetes_namespace_v1.ns[var.namespace_name].metadata[0].name : data.kubernetes_namespace.ns[var.namespace_name].metadata[0].name
   }
   data = {
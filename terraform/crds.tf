@@ -0,0 +1,38 @@
+locals {
+  vaultroles_crd                 = yamldecode(file("${path.module}/../config/crd/bases/vault.patoarvizu.dev_vaultroles.yaml"))
+  vaultpolicies_crd              = yamldecode(file("${path.module}/../config/crd/bases/vault.patoarvizu.dev_vaultpolicies.yaml"))
+  vaultdynamicconfigurations_crd = yamldecode(file("${path.module}/../config/crd/bases/vault.patoarvizu.dev_vaultdynamicconfigurations.yaml"))
+}
+
+resource kubernetes_manifest vaultroles_crd {
+  manifest = {
+    apiVersion = local.vaultroles_crd.apiVersion
+    kind       = local.vaultroles_crd.kind
+    metadata = {
+      name = local.vaultroles_crd.metadata.name
+    }
+    spec = local.vaultroles_crd.spec
+  }
+}
+
+resource kubernetes_manifest vaultpolicies_crd {
+  manifest = {
+    apiVersion = local.vaultpolicies_crd.apiVersion
+    kind       = local.vaultpolicies_crd.kind
+    metadata = {
+      name = local.vaultpolicies_crd.metadata.name
+    }
+    spec = local.vaultpolicies_crd.spec
+  }
+}
+
+resource kubernetes_manifest vaultdynamicconfigurations_crd {
+  manifest = {
+    apiVersion = local.vaultdynamicconfigurations_crd.apiVersion
+    kind       = local.vaultdynamicconfigurations_crd.kind
+    metadata = {
+      name = local.vaultdynamicconfigurations_crd.metadata.name
+    }
+    spec = local.vaultdynamicconfigurations_crd.spec
+  }
+}
@@ -39,6 +39,16 @@ resource kubernetes_deployment_v1 deployment {
             "--auto-configure-annotation=${var.flag_auto_configure_annotation}",
             "--auto-configuredb-creds-annotation=${var.flag_auto_configure_db_creds_annotation}",
             "--token-ttl=${var.flag_token_ttl}",
+            "--configuration-name=${var.flag_configuration_name}",
+            "--cluster-name=${var.flag_cluster_name}",
+            "--debounce-window=${var.flag_debounce_window}",
+            "--include-namespaces=${var.flag_include_namespaces}",
+            "--exclude-namespaces=${var.flag_exclude_namespaces}",
+            "--namespace-selector=${var.flag_namespace_selector}",
+            "--service-account-selector=${var.flag_service_account_selector}",
+            "--naming-strategy=${var.flag_naming_strategy}",
+            "--naming-template=${var.flag_naming_template}",
+            "--keep-legacy-names=${tostring(var.flag_keep_legacy_names)}",
             "--bound-roles-to-all-namespaces=${tostring(var.flag_bound_roles_to_all_namespaces)}"
           ]
 
@@ -9,6 +9,12 @@ resource kubernetes_cluster_role_v1 cluster_role {
     resources  = ["configmaps"]
   }
 
+  rule {
+    verbs      = ["create", "patch"]
+    api_groups = [""]
+    resources  = ["events"]
+  }
+
   rule {
     verbs      = ["get", "list", "watch"]
     api_groups = [""]
@@ -16,7 +22,7 @@ resource kubernetes_cluster_role_v1 cluster_role {
   }
 
   rule {
-    verbs      = ["get", "list", "watch"]
+    verbs      = ["get", "list", "patch", "update", "watch"]
     api_groups = [""]
     resources  = ["serviceaccounts"]
   }
@@ -26,6 +32,42 @@ resource kubernetes_cluster_role_v1 cluster_role {
     api_groups = ["vault.banzaicloud.com"]
     resources  = ["vaults"]
   }
+
+  rule {
+    verbs      = ["get", "list", "patch", "update", "watch"]
+    api_groups = ["vault.patoarvizu.dev"]
+    resources  = ["vaultroles"]
+  }
+
+  rule {
+    verbs      = ["get", "patch", "update"]
+    api_groups = ["vault.patoarvizu.dev"]
+    resources  = ["vaultroles/status"]
+  }
+
+  rule {
+    verbs      = ["get", "list", "patch", "update", "watch"]
+    api_groups = ["vault.patoarvizu.dev"]
+    resources  = ["vaultpolicies"]
+  }
+
+  rule {
+    verbs      = ["get", "patch", "update"]
+    api_groups = ["vault.patoarvizu.dev"]
+    resources  = ["vaultpolicies/status"]
+  }
+
+  rule {
+    verbs      = ["get", "list", "watch"]
+    api_groups = ["vault.patoarvizu.dev"]
+    resources  = ["vaultdynamicconfigurations"]
+  }
+
+  rule {
+    verbs      = ["get", "patch", "update"]
+    api_groups = ["vault.patoarvizu.dev"]
+    resources  = ["vaultdynamicconfigurations/status"]
+  }
 }
 
 resource kubernetes_cluster_role_binding_v1 cluster_role_binding {
@@ -52,6 +52,66 @@ variable flag_token_ttl {
   description = "The value of the --token-ttl flag"
 }
 
+variable flag_cluster_name {
+  type = string
+  default = ""
+  description = "The value of the --cluster-name flag"
+}
+
+variable flag_debounce_window {
+  type = string
+  default = "5s"
+  description = "The value of the --debounce-window flag"
+}
+
+variable flag_include_namespaces {
+  type = string
+  default = ""
+  description = "The value of the --include-namespaces flag"
+}
+
+variable flag_exclude_namespaces {
+  type = string
+  default = "kube-system,kube-public,kube-node-lease"
+  description = "The value of the --exclude-namespaces flag"
+}
+
+variable flag_namespace_selector {
+  type = string
+  default = ""
+  description = "The value of the --namespace-selector flag"
+}
+
+variable flag_service_account_selector {
+  type = string
+  default = ""
+  description = "The value of the --service-account-selector flag"
+}
+
+variable flag_naming_strategy {
+  type = string
+  default = "name"
+  description = "The value of the --naming-strategy flag"
+}
+
+variable flag_naming_template {
+  type = string
+  default = ""
+  description = "The value of the --naming-template flag"
+}
+
+variable flag_keep_legacy_names {
+  type = bool
+  default = false
+  description = "The value of the --keep-legacy-names flag"
+}
+
+variable flag_configuration_name {
+  type = string
+  default = "vault-dynamic-configuration"
+  description = "The value of the --configuration-name flag"
+}
+
 variable flag_bound_roles_to_all_namespaces {
   type = bool
   default = false
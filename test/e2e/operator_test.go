@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	bankvaultsv1alpha1 "github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	vaultv1alpha1 "github.com/patoarvizu/vault-dynamic-configuration-operator/api/v1alpha1"
 	"github.com/patoarvizu/vault-dynamic-configuration-operator/controllers"
 	apiv1 "k8s.io/api/core/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -19,6 +21,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -53,6 +56,8 @@ var _ = BeforeSuite(func(done Done) {
 
 	err = bankvaultsv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = vaultv1alpha1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 	// +kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
@@ -104,6 +109,485 @@ var _ = Describe("Single namespace", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 	})
+	Context("When an annotated service account is deleted", func() {
+		It("Should remove the corresponding Vault role, policy and DB role", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-delete", "default", map[string]string{"vault.patoarvizu.dev/db-dynamic-creds": "mysql"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-delete", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-delete")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When one of multiple service accounts sharing a role is deleted", func() {
+		It("Should only remove its namespace from the Vault role", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-delete-shared", "test-vdc1", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount2, err = createServiceAccount("operator-test-delete-shared", "test-vdc2", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-delete-shared", []string{"test-vdc1", "test-vdc2"})
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleNamespaceRemoved("operator-test-delete-shared", "test-vdc1")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-delete-shared", []string{"test-vdc2"})
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount2)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has token settings annotations", func() {
+		It("Should set them on the Vault role, and update them when they change", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-token", "default", map[string]string{"vault.patoarvizu.dev/token-ttl": "1h", "vault.patoarvizu.dev/token-type": "batch"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleTokenSettings("operator-test-token", "1h", "batch")
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1.Annotations["vault.patoarvizu.dev/token-ttl"] = "2h"
+			err = k8sClient.Update(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleTokenSettings("operator-test-token", "2h", "batch")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the DB role annotation", func() {
+		It("Should allow its policy to read the DB credentials", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-db-policy", "default", map[string]string{"vault.patoarvizu.dev/db-dynamic-creds": "mysql"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-db-policy", `path "database/creds/operator-test-db-policy"`)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When a service account has the DB annotation with multiple connections", func() {
+		It("Should create a Vault DB role for each connection, allowed on its connection", func() {
+			err = copyDatabaseConnection("mysql", "operator-test-mysql-2")
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-multi-db", "default", map[string]string{"vault.patoarvizu.dev/db-dynamic-creds": "mysql,operator-test-mysql-2"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultDBRoleConnection("operator-test-multi-db-mysql", "mysql")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultDBRoleConnection("operator-test-multi-db-operator-test-mysql-2", "operator-test-mysql-2")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-multi-db", `path "database/creds/operator-test-multi-db-mysql"`)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-multi-db", `path "database/creds/operator-test-multi-db-operator-test-mysql-2"`)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-multi-db-mysql")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-multi-db-operator-test-mysql-2")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeDatabaseConnection("operator-test-mysql-2")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When the database TTL annotations of a service account change", func() {
+		It("Should update the existing Vault DB role", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-db-ttl", "default", map[string]string{"vault.patoarvizu.dev/db-dynamic-creds": "mysql"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultDBRole("operator-test-db-ttl")
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1.Annotations["vault.patoarvizu.dev/db-default-ttl"] = "2h"
+			serviceAccount1.Annotations["vault.patoarvizu.dev/db-max-ttl"] = "48h"
+			err = k8sClient.Update(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultDBRoleTtls("operator-test-db-ttl", "2h", "48h")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the same name as a role that wasn't created by the operator", func() {
+		It("Should NOT overwrite the role, and report the collision", func() {
+			err = addHandWrittenRole("operator-test-collision", "operator-test-hand-written")
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-collision", "default", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			err = testServiceAccountEvent("operator-test-collision", "default", "NameCollision")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRolePolicy("operator-test-collision", "operator-test-hand-written")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-collision", "path")
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRolePolicy("operator-test-collision", "operator-test-hand-written")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeHandWrittenRole("operator-test-collision", "operator-test-hand-written")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the AWS role annotation but the AWS secrets engine isn't configured", func() {
+		It("Should NOT create a Vault role for it", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-aws-role", "default", map[string]string{"vault.patoarvizu.dev/aws-role-arn": "arn:aws:iam::123456789012:role/operator-test"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-aws-role", []string{"default"})
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the AWS role annotation and the AWS secrets engine is configured", func() {
+		It("Should create an AWS role for it and give its policy access to its credentials", func() {
+			err = addSecretsEngine(map[string]interface{}{"type": "aws", "path": "operator-test-aws"})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-aws-role-configured", "default", map[string]string{"vault.patoarvizu.dev/aws-role-arn": "arn:aws:iam::123456789012:role/operator-test"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-aws-role-configured", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntry("aws", "roles", "operator-test-aws-role-configured", map[string]interface{}{
+				"credential_type": "assumed_role",
+				"role_arns":       []string{"arn:aws:iam::123456789012:role/operator-test"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-aws-role-configured", "path \"operator-test-aws/creds/operator-test-aws-role-configured\" {\n  capabilities = [\"read\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntryRemoved("aws", "roles", "operator-test-aws-role-configured")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeSecretsEngine("aws")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the PKI role annotation but the PKI secrets engine isn't configured", func() {
+		It("Should NOT create a Vault role for it", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-pki-role", "default", map[string]string{"vault.patoarvizu.dev/pki-role": "true"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-pki-role", []string{"default"})
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the PKI role annotation and the PKI secrets engine is configured", func() {
+		It("Should create a PKI role for it and give its policy access to issue certificates", func() {
+			err = addSecretsEngine(map[string]interface{}{"type": "pki", "path": "operator-test-pki"})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-pki-role-configured", "default", map[string]string{"vault.patoarvizu.dev/pki-role": "true"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-pki-role-configured", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntry("pki", "roles", "operator-test-pki-role-configured", map[string]interface{}{
+				"allowed_domains":  []string{"operator-test-pki-role-configured.default.svc", "operator-test-pki-role-configured.default.svc.cluster.local"},
+				"allow_subdomains": false,
+				"max_ttl":          "72h",
+				"key_type":         "rsa",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-pki-role-configured", "path \"operator-test-pki/issue/operator-test-pki-role-configured\" {\n  capabilities = [\"update\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntryRemoved("pki", "roles", "operator-test-pki-role-configured")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeSecretsEngine("pki")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has an unknown level of KV access", func() {
+		It("Should NOT create a Vault role for it", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-kv-access", "default", map[string]string{"vault.patoarvizu.dev/kv-access": "admin"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-kv-access", []string{"default"})
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the KV access annotation and version 1 of the KV secrets engine is configured", func() {
+		It("Should give its policy access to its KV path", func() {
+			err = addSecretsEngine(map[string]interface{}{"type": "kv", "path": "operator-test-kv", "options": map[string]interface{}{"version": 1}})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-kv-v1", "default", map[string]string{"vault.patoarvizu.dev/kv-access": "read"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-kv-v1", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-kv-v1", "path \"operator-test-kv/operator-test-kv-v1\" {\n  capabilities = [\"read\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-kv-v1", "operator-test-kv/data/")
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-kv-v1")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeSecretsEngine("kv")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the KV access annotation and version 2 of the KV secrets engine is configured", func() {
+		It("Should give its policy access to the data, metadata and delete paths of its KV path", func() {
+			err = addSecretsEngine(map[string]interface{}{"type": "kv", "path": "operator-test-kv", "options": map[string]interface{}{"version": 2}})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-kv-v2", "default", map[string]string{"vault.patoarvizu.dev/kv-access": "write"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-kv-v2", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-kv-v2", "path \"operator-test-kv/data/operator-test-kv-v2\" {\n  capabilities = [\"create\", \"read\", \"update\", \"delete\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-kv-v2", "path \"operator-test-kv/metadata/operator-test-kv-v2\" {\n  capabilities = [\"read\", \"list\", \"delete\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-kv-v2", "path \"operator-test-kv/delete/operator-test-kv-v2\" {\n  capabilities = [\"update\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-kv-v2")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeSecretsEngine("kv")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has an invalid transit key type", func() {
+		It("Should NOT create a Vault role for it", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-transit-key", "default", map[string]string{"vault.patoarvizu.dev/transit-key": "true", "vault.patoarvizu.dev/transit-key-type": "ed25519"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-transit-key", []string{"default"})
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the transit key annotation and the transit secrets engine is configured", func() {
+		It("Should create a transit key for it and give its policy access to encrypt and decrypt with it", func() {
+			err = addSecretsEngine(map[string]interface{}{"type": "transit", "path": "operator-test-transit"})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-transit-key-configured", "default", map[string]string{"vault.patoarvizu.dev/transit-key": "true", "vault.patoarvizu.dev/transit-key-type": "aes256-gcm96"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-transit-key-configured", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntry("transit", "keys", "operator-test-transit-key-configured", map[string]interface{}{
+				"type": "aes256-gcm96",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-transit-key-configured", "path \"operator-test-transit/encrypt/operator-test-transit-key-configured\" {\n  capabilities = [\"update\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-transit-key-configured", "path \"operator-test-transit/decrypt/operator-test-transit-key-configured\" {\n  capabilities = [\"update\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntryRemoved("transit", "keys", "operator-test-transit-key-configured")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeSecretsEngine("transit")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has an invalid SSH role annotation", func() {
+		It("Should NOT create a Vault role for it", func() {
+			serviceAccount1, err = createServiceAccount("operator-test-ssh-role", "default", map[string]string{"vault.patoarvizu.dev/ssh-role": "sometimes"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-ssh-role", []string{"default"})
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the SSH role annotation and the SSH secrets engine is configured", func() {
+		It("Should create an SSH role for it and give its policy access to sign certificates", func() {
+			err = addSecretsEngine(map[string]interface{}{"type": "ssh", "path": "operator-test-ssh"})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-ssh-role-configured", "default", map[string]string{"vault.patoarvizu.dev/ssh-role": "true"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-ssh-role-configured", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntry("ssh", "roles", "operator-test-ssh-role-configured", map[string]interface{}{
+				"key_type":                "ca",
+				"allow_user_certificates": true,
+				"allowed_users":           "operator-test-ssh-role-configured",
+				"default_user":            "operator-test-ssh-role-configured",
+				"ttl":                     "1h",
+				"max_ttl":                 "24h",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyContains("operator-test-ssh-role-configured", "path \"operator-test-ssh/sign/operator-test-ssh-role-configured\" {\n  capabilities = [\"update\"]\n}")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = testSecretsEngineEntryRemoved("ssh", "roles", "operator-test-ssh-role-configured")
+			Expect(err).ToNot(HaveOccurred())
+			err = removeSecretsEngine("ssh")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When service account has the policy templates annotation", func() {
+		It("Should render and attach a policy for each template", func() {
+			configuration := &vaultv1alpha1.VaultDynamicConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "vault-dynamic-configuration",
+				},
+				Spec: vaultv1alpha1.VaultDynamicConfigurationSpec{
+					PolicyTemplate: "path \"secret/{{ .Name }}\" {\n  capabilities = [\"read\"]\n}\n",
+					PolicyTemplates: map[string]string{
+						"kv-writer": "path \"secret/{{ .Name }}\" {\n  capabilities = [\"create\", \"update\"]\n}\n",
+					},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-templates", "default", map[string]string{"vault.patoarvizu.dev/policy-templates": "kv-writer"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-templates", []string{"default"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRolePolicy("operator-test-templates", "operator-test-templates-kv-writer")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When a VaultRole is created", func() {
+		It("Should create the corresponding Vault role and policy, and remove them when it's deleted", func() {
+			vaultRole := &vaultv1alpha1.VaultRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "operator-test-vaultrole",
+					Namespace: "default",
+				},
+				Spec: vaultv1alpha1.VaultRoleSpec{
+					ServiceAccounts: []string{"operator-test-vaultrole"},
+					Policies: []vaultv1alpha1.VaultRolePolicy{
+						{
+							Name:     "operator-test-vaultrole",
+							Template: "path \"secret/{{ .Name }}\" {\n  capabilities = [\"read\"]\n}\n",
+						},
+					},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), vaultRole)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleBinding("default.operator-test-vaultrole", "operator-test-vaultrole", "default")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), vaultRole)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("default.operator-test-vaultrole")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When VaultRoles with the same name are created in different namespaces", func() {
+		It("Should create a Vault role for each namespace", func() {
+			vaultRoles := []*vaultv1alpha1.VaultRole{}
+			for _, ns := range []string{"test-vdc1", "test-vdc2"} {
+				vaultRole := &vaultv1alpha1.VaultRole{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "operator-test-vaultrole-shared",
+						Namespace: ns,
+					},
+					Spec: vaultv1alpha1.VaultRoleSpec{
+						ServiceAccounts: []string{"operator-test-vaultrole-shared"},
+						Policies: []vaultv1alpha1.VaultRolePolicy{
+							{
+								Name:     "operator-test-vaultrole-shared",
+								Template: "path \"secret/{{ .Namespace }}/{{ .Name }}\" {\n  capabilities = [\"read\"]\n}\n",
+							},
+						},
+					},
+				}
+				err = k8sClient.Create(context.TODO(), vaultRole)
+				Expect(err).ToNot(HaveOccurred())
+				vaultRoles = append(vaultRoles, vaultRole)
+			}
+			err = testVaultRoleBinding("test-vdc1.operator-test-vaultrole-shared", "operator-test-vaultrole-shared", "test-vdc1")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleBinding("test-vdc2.operator-test-vaultrole-shared", "operator-test-vaultrole-shared", "test-vdc2")
+			Expect(err).ToNot(HaveOccurred())
+			for _, vaultRole := range vaultRoles {
+				err = k8sClient.Delete(context.TODO(), vaultRole)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			err = testVaultRoleRemoved("test-vdc1.operator-test-vaultrole-shared")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("test-vdc2.operator-test-vaultrole-shared")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When a VaultPolicy template uses labels and Sprig functions", func() {
+		It("Should render them into the policy", func() {
+			vaultPolicy := &vaultv1alpha1.VaultPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "operator-test-vaultpolicy-labels",
+					Namespace: "default",
+					Labels:    map[string]string{"team": "payments"},
+				},
+				Spec: vaultv1alpha1.VaultPolicySpec{
+					Template: "path \"secret/{{ .Labels.team }}/{{ .Namespace | upper }}/*\" {\n  capabilities = [\"read\"]\n}\n",
+				},
+			}
+			err = k8sClient.Create(context.TODO(), vaultPolicy)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultPolicyRules("default.operator-test-vaultpolicy-labels", "path \"secret/payments/DEFAULT/*\" {\n  capabilities = [\"read\"]\n}\n")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), vaultPolicy)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When the policy template can't be rendered for a service account", func() {
+		It("Should NOT create a Vault role or policy for it", func() {
+			configuration := &vaultv1alpha1.VaultDynamicConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "vault-dynamic-configuration",
+				},
+				Spec: vaultv1alpha1.VaultDynamicConfigurationSpec{
+					PolicyTemplate: "path \"secret/{{ .Labels.team }}\" {\n  capabilities = [\"read\"]\n}\n",
+				},
+			}
+			err = k8sClient.Create(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount1, err = createServiceAccount("operator-test-template-error", "default", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-template-error", []string{"default"})
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When the VaultDynamicConfiguration is invalid", func() {
+		It("Should report it in the status", func() {
+			configuration := &vaultv1alpha1.VaultDynamicConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "vault-dynamic-configuration",
+				},
+				Spec: vaultv1alpha1.VaultDynamicConfigurationSpec{
+					DbMaxTtl: "forever",
+				},
+			}
+			err = k8sClient.Create(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+			err = testConfigurationCondition("vault-dynamic-configuration", metav1.ConditionFalse)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When the VaultDynamicConfiguration has invalid database statements", func() {
+		It("Should report it in the status", func() {
+			configuration := &vaultv1alpha1.VaultDynamicConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "vault-dynamic-configuration",
+				},
+				Spec: vaultv1alpha1.VaultDynamicConfigurationSpec{
+					DatabasePlugins: map[string]vaultv1alpha1.DatabaseStatements{
+						"mongodb-database-plugin": {
+							CreationStatements: []string{"db.createUser()"},
+						},
+					},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+			err = testConfigurationCondition("vault-dynamic-configuration", metav1.ConditionFalse)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
 })
 
 var _ = Describe("All namespaces", func() {
@@ -132,31 +616,145 @@ var _ = Describe("Any namespace", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Context("When annotating a service account in an excluded namespace", func() {
+		It("Should NOT create a Vault role for it", func() {
+			serviceAccount, err := createServiceAccount("operator-test-excluded", "kube-system", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			// All service accounts are applied together, so once the one in an included namespace is
+			// configured, the excluded one would've been too.
+			includedServiceAccount, err := createServiceAccount("operator-test-included", "default", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRole("operator-test-included", []string{"*"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-excluded")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), includedServiceAccount)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-included")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When creating a VaultRole in an excluded namespace", func() {
+		It("Should NOT create a Vault role for it, and report it as out of scope", func() {
+			vaultRole := &vaultv1alpha1.VaultRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "operator-test-vaultrole-excluded",
+					Namespace: "kube-system",
+				},
+				Spec: vaultv1alpha1.VaultRoleSpec{
+					ServiceAccounts: []string{"operator-test-vaultrole-excluded"},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), vaultRole)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleCondition("operator-test-vaultrole-excluded", "kube-system", metav1.ConditionFalse, "OutOfScope")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("kube-system.operator-test-vaultrole-excluded")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), vaultRole)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
 })
 
-var _ = AfterSuite(func() {
-	By("tearing down the test environment")
-	err := testEnv.Stop()
-	Expect(err).ToNot(HaveOccurred())
-	err = testKubernetesConfigStillExists()
-	Expect(err).ToNot(HaveOccurred())
-})
-
-func newTrue() *bool {
-	b := true
-	return &b
-}
-
-func createServiceAccount(name string, namespace string, extraAnnotations map[string]string) (serviceAccount *apiv1.ServiceAccount, err error) {
-	annotations := map[string]string{"vault.patoarvizu.dev/auto-configure": "true"}
-	for k, v := range extraAnnotations {
-		annotations[k] = v
-	}
-	var operatorTestServiceAccount = &apiv1.ServiceAccount{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        name,
-			Namespace:   namespace,
-			Annotations: annotations,
+var _ = Describe("Namespace name", func() {
+	Context("When a service account has the annotation", func() {
+		It("Should create a Vault role named after its namespace and name, and keep the legacy one", func() {
+			serviceAccount, err := createServiceAccount("operator-test-naming", "default", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleBinding("default.operator-test-naming", "operator-test-naming", "default")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleBinding("operator-test-naming", "operator-test-naming", "default")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("default.operator-test-naming")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-naming")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When the database statements use the role name", func() {
+		It("Should render the name of the Vault role into them", func() {
+			configuration := &vaultv1alpha1.VaultDynamicConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "vault-dynamic-configuration",
+				},
+				Spec: vaultv1alpha1.VaultDynamicConfigurationSpec{
+					DatabasePlugins: map[string]vaultv1alpha1.DatabaseStatements{
+						"mysql-database-plugin": {
+							CreationStatements: []string{"CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}'; GRANT SELECT ON `[[ .RoleName ]]`.* TO '{{name}}'@'%';"},
+						},
+					},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+			err = testConfigurationCondition("vault-dynamic-configuration", metav1.ConditionTrue)
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount, err := createServiceAccount("operator-test-naming-db", "default", map[string]string{"vault.patoarvizu.dev/db-dynamic-creds": "mysql"})
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultDBRoleCreationStatement("default.operator-test-naming-db", "GRANT SELECT ON `default.operator-test-naming-db`.*")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("default.operator-test-naming-db")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), configuration)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Context("When a service account gets the legacy name of a service account in another namespace", func() {
+		It("Should NOT create a Vault role with that name, and report the collision", func() {
+			serviceAccount1, err := createServiceAccount("operator-test-naming-collision", "default", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			serviceAccount2, err := createServiceAccount("default.operator-test-naming-collision", "test-vdc1", map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+			err = testServiceAccountEvent("operator-test-naming-collision", "default", "NameCollision")
+			Expect(err).ToNot(HaveOccurred())
+			err = testServiceAccountEvent("default.operator-test-naming-collision", "test-vdc1", "NameCollision")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleBinding("operator-test-naming-collision", "operator-test-naming-collision", "default")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("default.operator-test-naming-collision")
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount1)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(context.TODO(), serviceAccount2)
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("operator-test-naming-collision")
+			Expect(err).ToNot(HaveOccurred())
+			err = testVaultRoleRemoved("test-vdc1.default.operator-test-naming-collision")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
+
+var _ = AfterSuite(func() {
+	By("tearing down the test environment")
+	err := testEnv.Stop()
+	Expect(err).ToNot(HaveOccurred())
+	err = testKubernetesConfigStillExists()
+	Expect(err).ToNot(HaveOccurred())
+})
+
+func newTrue() *bool {
+	b := true
+	return &b
+}
+
+func createServiceAccount(name string, namespace string, extraAnnotations map[string]string) (serviceAccount *apiv1.ServiceAccount, err error) {
+	annotations := map[string]string{"vault.patoarvizu.dev/auto-configure": "true"}
+	for k, v := range extraAnnotations {
+		annotations[k] = v
+	}
+	var operatorTestServiceAccount = &apiv1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
 		},
 	}
 	e := k8sClient.Create(context.TODO(), operatorTestServiceAccount)
@@ -246,6 +844,106 @@ func testVaultRole(name string, namespaces []string) error {
 	return err
 }
 
+func testVaultRoleTokenSettings(name string, tokenTtl string, tokenType string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		role, wErr := bvConfig.GetRole(name)
+		if wErr != nil {
+			return false, nil
+		}
+		if role.TokenTtl != tokenTtl || role.TokenType != tokenType {
+			return false, nil
+		}
+		return true, nil
+	})
+	return err
+}
+
+func testVaultRolePolicy(name string, policyName string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		role, wErr := bvConfig.GetRole(name)
+		if wErr != nil {
+			return false, nil
+		}
+		for _, p := range role.TokenPolicies {
+			if p == policyName {
+				_, wErr = bvConfig.GetPolicy(policyName)
+				return wErr == nil, nil
+			}
+		}
+		return false, nil
+	})
+	return err
+}
+
+func testVaultPolicyRules(name string, rules string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		policy, wErr := bvConfig.GetPolicy(name)
+		if wErr != nil {
+			return false, nil
+		}
+		if policy.Rules != rules {
+			return true, errors.New(fmt.Sprintf("Policy '%s' rules are not rendered correctly", name))
+		}
+		return true, nil
+	})
+	return err
+}
+
+func testVaultPolicyContains(name string, stanza string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		policy, wErr := bvConfig.GetPolicy(name)
+		if wErr != nil {
+			return false, nil
+		}
+		return strings.Contains(policy.Rules, stanza), nil
+	})
+	return err
+}
+
 func testVaultDBRole(name string) error {
 	vaultCR := &bankvaultsv1alpha1.Vault{}
 	bvConfig := controllers.BankVaultsConfig{}
@@ -283,3 +981,425 @@ func testVaultDBRole(name string) error {
 	})
 	return err
 }
+
+func testVaultDBRoleTtls(name string, defaultTtl string, maxTtl string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		role, wErr := bvConfig.GetDBRole(name)
+		if wErr != nil {
+			return false, nil
+		}
+		if role.DefaultTtl != defaultTtl || role.MaxTtl != maxTtl {
+			return false, nil
+		}
+		return true, nil
+	})
+	return err
+}
+
+func testVaultRoleRemoved(name string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		bvConfig := controllers.BankVaultsConfig{}
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		if _, wErr = bvConfig.GetRole(name); wErr == nil {
+			return false, nil
+		}
+		if _, wErr = bvConfig.GetPolicy(name); wErr == nil {
+			return false, nil
+		}
+		if _, wErr = bvConfig.GetDBRole(name); wErr == nil {
+			return false, nil
+		}
+		dbSecret, wErr := bvConfig.GetDBSecret()
+		if wErr != nil {
+			return true, nil
+		}
+		for _, c := range dbSecret.Configuration.Config {
+			for _, r := range c.AllowedRoles {
+				if r == name {
+					return false, nil
+				}
+			}
+		}
+		return true, nil
+	})
+	return err
+}
+
+func testVaultRoleNamespaceRemoved(name string, namespace string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		bvConfig := controllers.BankVaultsConfig{}
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		role, wErr := bvConfig.GetRole(name)
+		if wErr != nil {
+			return true, errors.New(fmt.Sprintf("Role '%s' was removed while it was still bound to other namespaces", name))
+		}
+		return !namespaceIsInAllowedList(namespace, role.BoundServiceAccountNamespaces), nil
+	})
+	return err
+}
+
+func testConfigurationCondition(name string, status metav1.ConditionStatus) error {
+	configuration := &vaultv1alpha1.VaultDynamicConfiguration{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		wErr := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name}, configuration)
+		if wErr != nil {
+			return false, nil
+		}
+		for _, c := range configuration.Status.Conditions {
+			if c.Type == "Valid" && c.Status == status {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	return err
+}
+
+func testVaultRoleCondition(name string, namespace string, status metav1.ConditionStatus, reason string) error {
+	vaultRole := &vaultv1alpha1.VaultRole{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		wErr := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, vaultRole)
+		if wErr != nil {
+			return false, nil
+		}
+		for _, c := range vaultRole.Status.Conditions {
+			if c.Type == "Applied" && c.Status == status && c.Reason == reason {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	return err
+}
+
+// updateExternalConfig changes the external configuration of the Vault CR, e.g. to add the secrets
+// engines a test needs.
+func updateExternalConfig(mutate func(config map[string]interface{})) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		vaultCR := &bankvaultsv1alpha1.Vault{}
+		err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		if err != nil {
+			return err
+		}
+		config := map[string]interface{}{}
+		err = json.Unmarshal([]byte(vaultCR.Spec.ExternalConfigJSON()), &config)
+		if err != nil {
+			return err
+		}
+		mutate(config)
+		vaultCR.Spec.ExternalConfig.Raw, err = json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		return k8sClient.Update(context.TODO(), vaultCR)
+	})
+}
+
+func addSecretsEngine(engine map[string]interface{}) error {
+	return updateExternalConfig(func(config map[string]interface{}) {
+		secrets, _ := config["secrets"].([]interface{})
+		config["secrets"] = append(secrets, engine)
+	})
+}
+
+func removeSecretsEngine(engineType string) error {
+	return updateExternalConfig(func(config map[string]interface{}) {
+		secrets, _ := config["secrets"].([]interface{})
+		kept := []interface{}{}
+		for _, s := range secrets {
+			if e, ok := s.(map[string]interface{}); ok && e["type"] == engineType {
+				continue
+			}
+			kept = append(kept, s)
+		}
+		config["secrets"] = kept
+	})
+}
+
+func getSecretsEngineEntry(engineType string, list string, name string) (map[string]interface{}, bool) {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+	if err != nil {
+		return nil, false
+	}
+	config := struct {
+		Secrets []map[string]interface{} `json:"secrets"`
+	}{}
+	err = json.Unmarshal([]byte(vaultCR.Spec.ExternalConfigJSON()), &config)
+	if err != nil {
+		return nil, false
+	}
+	for _, e := range config.Secrets {
+		if e["type"] != engineType {
+			continue
+		}
+		configuration, _ := e["configuration"].(map[string]interface{})
+		entries, _ := configuration[list].([]interface{})
+		for _, entry := range entries {
+			if m, ok := entry.(map[string]interface{}); ok && m["name"] == name {
+				return m, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func testSecretsEngineEntry(engineType string, list string, name string, fields map[string]interface{}) error {
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		entry, ok := getSecretsEngineEntry(engineType, list, name)
+		if !ok {
+			return false, nil
+		}
+		for k, v := range fields {
+			if fmt.Sprint(entry[k]) != fmt.Sprint(v) {
+				return true, errors.New(fmt.Sprintf("Field '%s' of %s entry '%s' is %v instead of %v", k, engineType, name, entry[k], v))
+			}
+		}
+		return true, nil
+	})
+	return err
+}
+
+func testSecretsEngineEntryRemoved(engineType string, list string, name string) error {
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		_, ok := getSecretsEngineEntry(engineType, list, name)
+		return !ok, nil
+	})
+	return err
+}
+
+// addHandWrittenRole adds a role and its policy to the Vault CR, the same as if they were written by
+// hand, i.e. without recording them as managed by the operator.
+func addHandWrittenRole(name string, policyName string) error {
+	return updateExternalConfig(func(config map[string]interface{}) {
+		auths, _ := config["auth"].([]interface{})
+		for _, a := range auths {
+			if auth, ok := a.(map[string]interface{}); ok && auth["type"] == "kubernetes" {
+				roles, _ := auth["roles"].([]interface{})
+				auth["roles"] = append(roles, map[string]interface{}{
+					"name":                             name,
+					"bound_service_account_names":      name,
+					"bound_service_account_namespaces": []string{"default"},
+					"token_policies":                   []string{policyName},
+				})
+			}
+		}
+		policies, _ := config["policies"].([]interface{})
+		config["policies"] = append(policies, map[string]interface{}{
+			"name":  policyName,
+			"rules": "path \"secret/hand-written\" {\n  capabilities = [\"read\"]\n}\n",
+		})
+	})
+}
+
+func removeHandWrittenRole(name string, policyName string) error {
+	return updateExternalConfig(func(config map[string]interface{}) {
+		auths, _ := config["auth"].([]interface{})
+		for _, a := range auths {
+			if auth, ok := a.(map[string]interface{}); ok && auth["type"] == "kubernetes" {
+				roles, _ := auth["roles"].([]interface{})
+				kept := []interface{}{}
+				for _, r := range roles {
+					if role, ok := r.(map[string]interface{}); ok && role["name"] == name {
+						continue
+					}
+					kept = append(kept, r)
+				}
+				auth["roles"] = kept
+			}
+		}
+		policies, _ := config["policies"].([]interface{})
+		kept := []interface{}{}
+		for _, p := range policies {
+			if policy, ok := p.(map[string]interface{}); ok && policy["name"] == policyName {
+				continue
+			}
+			kept = append(kept, p)
+		}
+		config["policies"] = kept
+	})
+}
+
+func testServiceAccountEvent(name string, namespace string, reason string) error {
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		events := &corev1.EventList{}
+		wErr := k8sClient.List(context.TODO(), events, client.InNamespace(namespace))
+		if wErr != nil {
+			return false, nil
+		}
+		for _, e := range events.Items {
+			if e.InvolvedObject.Kind == "ServiceAccount" && e.InvolvedObject.Name == name && e.Reason == reason {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	return err
+}
+
+func testVaultRoleBinding(name string, serviceAccountName string, namespace string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		role, wErr := bvConfig.GetRole(name)
+		if wErr != nil {
+			return false, nil
+		}
+		if role.BoundServiceAccountNames != serviceAccountName || !namespaceIsInAllowedList(namespace, role.BoundServiceAccountNamespaces) {
+			return true, errors.New(fmt.Sprintf("Role '%s' isn't bound to service account '%s' in namespace '%s'", name, serviceAccountName, namespace))
+		}
+		if role.TokenPolicies[0] != name {
+			return true, errors.New(fmt.Sprintf("Test role '%s' policies are not configured correctly", name))
+		}
+		return true, nil
+	})
+	return err
+}
+
+// copyDatabaseConnection adds a connection to the database secrets engine of the Vault CR, with the
+// same settings as an existing one.
+func copyDatabaseConnection(from string, name string) error {
+	return updateDatabaseConnections(func(connections []interface{}) []interface{} {
+		for _, c := range connections {
+			if connection, ok := c.(map[string]interface{}); ok && connection["name"] == from {
+				copied := map[string]interface{}{}
+				for k, v := range connection {
+					copied[k] = v
+				}
+				copied["name"] = name
+				copied["allowed_roles"] = []string{}
+				return append(connections, copied)
+			}
+		}
+		return connections
+	})
+}
+
+func removeDatabaseConnection(name string) error {
+	return updateDatabaseConnections(func(connections []interface{}) []interface{} {
+		kept := []interface{}{}
+		for _, c := range connections {
+			if connection, ok := c.(map[string]interface{}); ok && connection["name"] == name {
+				continue
+			}
+			kept = append(kept, c)
+		}
+		return kept
+	})
+}
+
+func updateDatabaseConnections(mutate func(connections []interface{}) []interface{}) error {
+	return updateExternalConfig(func(config map[string]interface{}) {
+		secrets, _ := config["secrets"].([]interface{})
+		for _, s := range secrets {
+			if secret, ok := s.(map[string]interface{}); ok && secret["type"] == "database" {
+				configuration, _ := secret["configuration"].(map[string]interface{})
+				connections, _ := configuration["config"].([]interface{})
+				configuration["config"] = mutate(connections)
+			}
+		}
+	})
+}
+
+func testVaultDBRoleConnection(name string, connection string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		role, wErr := bvConfig.GetDBRole(name)
+		if wErr != nil {
+			return false, nil
+		}
+		if role.DbName != connection {
+			return true, errors.New(fmt.Sprintf("DB role '%s' is for connection '%s' instead of '%s'", name, role.DbName, connection))
+		}
+		dbSecret, wErr := bvConfig.GetDBSecret()
+		if wErr != nil {
+			return false, nil
+		}
+		dbConfig, wErr := dbSecret.Configuration.GetDBConfig(connection)
+		if wErr != nil {
+			return false, nil
+		}
+		for _, r := range dbConfig.AllowedRoles {
+			if r == name {
+				return true, nil
+			}
+		}
+		return true, errors.New(fmt.Sprintf("DB role '%s' is missing from the allowed_roles of connection '%s'", name, connection))
+	})
+	return err
+}
+
+func testVaultDBRoleCreationStatement(name string, statement string) error {
+	vaultCR := &bankvaultsv1alpha1.Vault{}
+	bvConfig := controllers.BankVaultsConfig{}
+	err := wait.Poll(time.Second*2, time.Second*20, func() (done bool, err error) {
+		k8sClient.Get(context.TODO(), types.NamespacedName{Name: "vault", Namespace: "vault"}, vaultCR)
+		jsonData, wErr := json.Marshal(vaultCR.Spec.ExternalConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		wErr = json.Unmarshal(jsonData, &bvConfig)
+		if wErr != nil {
+			return false, nil
+		}
+		role, wErr := bvConfig.GetDBRole(name)
+		if wErr != nil {
+			return false, nil
+		}
+		for _, s := range role.CreationStatements {
+			if strings.Contains(s, statement) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	return err
+}